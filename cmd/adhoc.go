@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+const (
+	defaultAdhocRateLimit = 6 // Runs per minute
+	defaultAdhocTimeout   = 60 * time.Second
+	maxAdhocTimeout       = 10 * time.Minute
+	maxAdhocArgs          = 64
+)
+
+// adhocAllowAll is the adhoc_allowed_commands entry that allows any command.
+const adhocAllowAll = "*"
+
+// adhocSearchPath is where bare command names are resolved, the same PATH jobs
+// run with. It is a variable so tests can replace it.
+var adhocSearchPath = minimalPath
+
+// adhocPolicy gates server-initiated ad-hoc commands. It is configured only
+// locally, so a compromised control plane cannot widen it.
+type adhocPolicy struct {
+	enabled   bool
+	allowAll  bool       // adhoc_allowed_commands contains "*"
+	allowed   [][]string // Allowed argv prefixes; empty allows nothing
+	rateLimit int        // Runs per minute
+	runAs     string     // System mode: user to run commands as
+
+	mu     sync.Mutex
+	recent []time.Time // Start times within the last minute
+}
+
+func newAdhocPolicy(config *Config) *adhocPolicy {
+	p := &adhocPolicy{rateLimit: defaultAdhocRateLimit}
+	if config == nil {
+		return p
+	}
+	p.enabled = config.AdhocEnabled
+	p.runAs = config.AdhocRunAs
+	if config.AdhocRateLimitPerMinute > 0 {
+		p.rateLimit = config.AdhocRateLimitPerMinute
+	}
+	for _, entry := range config.AdhocAllowedCommands {
+		if strings.TrimSpace(entry) == adhocAllowAll {
+			p.allowAll = true
+		} else if fields := strings.Fields(entry); len(fields) > 0 {
+			p.allowed = append(p.allowed, fields)
+		}
+	}
+	if p.enabled && !p.allowAll && len(p.allowed) == 0 {
+		log.Printf("Warning: adhoc_enabled is set but adhoc_allowed_commands is empty; all ad-hoc commands will be rejected (use %q to allow any command)", adhocAllowAll)
+	}
+	return p
+}
+
+// resolveAdhocCommand returns the absolute path of an ad-hoc command. Bare
+// names are looked up in adhocSearchPath rather than the daemon's PATH, so
+// they resolve to the binary the job would run with its minimal environment.
+func resolveAdhocCommand(name string) (string, error) {
+	if strings.Contains(name, "/") {
+		if !filepath.IsAbs(name) || filepath.Clean(name) != name {
+			return "", fmt.Errorf("command path %q must be a clean absolute path", name)
+		}
+		return name, nil
+	}
+	for _, dir := range filepath.SplitList(adhocSearchPath) {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("command %q not found in %s", name, adhocSearchPath)
+}
+
+// permits reports whether argv, whose first element is already resolved to an
+// absolute path, is allowed. Arguments are matched literally against each
+// allowlist entry as a prefix, after resolving the entry's command the same
+// way, so "systemctl status" allows "systemctl status nginx" but not
+// "systemctl stop nginx". An empty allowlist allows nothing.
+func (p *adhocPolicy) permits(argv []string) bool {
+	if p.allowAll {
+		return true
+	}
+	for _, prefix := range p.allowed {
+		if len(argv) < len(prefix) {
+			continue
+		}
+		command, err := resolveAdhocCommand(prefix[0])
+		if err != nil || argv[0] != command {
+			continue
+		}
+		match := true
+		for i := 1; i < len(prefix); i++ {
+			if argv[i] != prefix[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// take records a run if the rate limit allows it.
+func (p *adhocPolicy) take(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cutoff := now.Add(-time.Minute)
+	kept := p.recent[:0]
+	for _, t := range p.recent {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	p.recent = kept
+
+	if len(p.recent) >= p.rateLimit {
+		return false
+	}
+	p.recent = append(p.recent, now)
+	return true
+}
+
+// execAdhoc runs a one-off command through exec mode and acknowledges the request.
+// Output is streamed and reported like any other execution.
+func (d *daemon) execAdhoc(executionID string, argv []string, timeoutSeconds int) {
+	ack := protocol.ExecAdhocAckMessage{Type: "exec_adhoc_ack", ExecutionID: executionID}
+
+	if err := d.startAdhocRun(&ack, argv, timeoutSeconds); err != nil {
+		log.Printf("Rejected exec_adhoc %q: %v", argv, err)
+		ack.Status = "rejected"
+		ack.Reason = err.Error()
+	} else {
+		// SECURITY: Ad-hoc commands are logged verbatim for auditability.
+		log.Printf("Started ad-hoc command %q (execution %s)", argv, ack.ExecutionID)
+		ack.Status = "started"
+	}
+
+	if err := d.sendMessage(ack); err != nil {
+		log.Printf("Failed to send exec_adhoc_ack: %v", err)
+	}
+}
+
+func (d *daemon) startAdhocRun(ack *protocol.ExecAdhocAckMessage, argv []string, timeoutSeconds int) error {
+	p := d.adhoc
+	if p == nil || !p.enabled {
+		return fmt.Errorf("ad-hoc commands are disabled on this agent")
+	}
+	if len(argv) == 0 || len(argv) > maxAdhocArgs {
+		return fmt.Errorf("invalid command")
+	}
+	for _, arg := range argv {
+		if strings.ContainsRune(arg, 0) {
+			return fmt.Errorf("invalid command")
+		}
+	}
+	// SECURITY: Match and run the resolved path, so the allowlist decides
+	// which binary runs, not whatever comes first on some PATH.
+	command, err := resolveAdhocCommand(argv[0])
+	if err != nil {
+		return err
+	}
+	argv = append([]string{command}, argv[1:]...)
+	if !p.permits(argv) {
+		return fmt.Errorf("command is not in the ad-hoc allowlist")
+	}
+	if err := validateRunAs(p.runAs, "", d.executionMode == "system", d.allowedRunAs); err != nil {
+		return err
+	}
+
+	if ack.ExecutionID == "" {
+		ack.ExecutionID = newExecutionID()
+	} else if !validExecutionID(ack.ExecutionID) {
+		return fmt.Errorf("invalid execution ID")
+	}
+
+	timeout := defaultAdhocTimeout
+	if timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+	if timeout > maxAdhocTimeout {
+		timeout = maxAdhocTimeout
+	}
+
+	if !p.take(time.Now()) {
+		return fmt.Errorf("ad-hoc rate limit of %d per minute exceeded", p.rateLimit)
+	}
+
+	// The command runs through exec mode, so it gets the same hardening as
+	// scheduled jobs: no-new-privs, minimal environment, output caps, streaming
+	// and reporting. It is executed directly, never through a shell.
+	args := []string{
+		"exec",
+		"--socket-path", socketPath,
+		"--state-dir", stateDir,
+		"--timeout", strconv.Itoa(int(timeout / time.Second)),
+		"--trigger", triggerAdhoc,
+		"--execution-id", ack.ExecutionID,
+	}
+	if p.runAs != "" {
+		args = append(args, "--run-as", p.runAs)
+	}
+	args = append(args, "--")
+	args = append(args, argv...)
+
+	if _, err := spawnExec(args); err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// fakeAdhocPath points command resolution at a temp dir holding stub
+// executables with the given names.
+func fakeAdhocPath(t *testing.T, names ...string) string {
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := adhocSearchPath
+	adhocSearchPath = dir
+	t.Cleanup(func() { adhocSearchPath = old })
+	return dir
+}
+
+func TestAdhocPolicy_Allowlist(t *testing.T) {
+	bin := fakeAdhocPath(t, "df", "systemctl")
+	p := newAdhocPolicy(&Config{AdhocEnabled: true, AdhocAllowedCommands: []string{"df -h", "systemctl status"}})
+
+	allowed := [][]string{{"df", "-h"}, {"systemctl", "status", "nginx"}, {bin + "/df", "-h"}}
+	denied := [][]string{{"df"}, {"systemctl", "stop", "nginx"}, {"/bin/df", "-h"}, {"sh", "-c", "df -h"}}
+
+	for _, argv := range allowed {
+		command, err := resolveAdhocCommand(argv[0])
+		if err != nil || !p.permits(append([]string{command}, argv[1:]...)) {
+			t.Errorf("permits(%q) = false (%v), want true", argv, err)
+		}
+	}
+	for _, argv := range denied {
+		command, err := resolveAdhocCommand(argv[0])
+		if err == nil && p.permits(append([]string{command}, argv[1:]...)) {
+			t.Errorf("permits(%q) = true, want false", argv)
+		}
+	}
+}
+
+func TestAdhocPolicy_EmptyAllowlistDeniesAll(t *testing.T) {
+	bin := fakeAdhocPath(t, "true")
+	empty := newAdhocPolicy(&Config{AdhocEnabled: true})
+	if empty.permits([]string{bin + "/true"}) {
+		t.Error("empty allowlist permits a command")
+	}
+	all := newAdhocPolicy(&Config{AdhocEnabled: true, AdhocAllowedCommands: []string{"*"}})
+	if !all.permits([]string{bin + "/true"}) {
+		t.Error(`"*" does not permit a command`)
+	}
+}
+
+func TestResolveAdhocCommand(t *testing.T) {
+	bin := fakeAdhocPath(t, "df")
+	// A decoy earlier on the daemon's PATH is never used.
+	decoy := t.TempDir()
+	os.WriteFile(filepath.Join(decoy, "df"), []byte("#!/bin/sh\n"), 0755)
+	t.Setenv("PATH", decoy+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if got, err := resolveAdhocCommand("df"); err != nil || got != bin+"/df" {
+		t.Errorf("resolveAdhocCommand(df) = %q, %v, want %q", got, err, bin+"/df")
+	}
+	for _, name := range []string{"missing", "./df", "bin/df", "/usr/../bin/df"} {
+		if got, err := resolveAdhocCommand(name); err == nil {
+			t.Errorf("resolveAdhocCommand(%q) = %q, want error", name, got)
+		}
+	}
+}
+
+func TestAdhocPolicy_DisabledByDefault(t *testing.T) {
+	d := &daemon{adhoc: newAdhocPolicy(&Config{})}
+	if err := d.startAdhocRun(&protocol.ExecAdhocAckMessage{}, []string{"true"}, 0); err == nil {
+		t.Errorf("Expected ad-hoc commands to be rejected when not enabled")
+	}
+}
+
+func TestAdhocPolicy_RateLimit(t *testing.T) {
+	p := newAdhocPolicy(&Config{AdhocEnabled: true, AdhocRateLimitPerMinute: 2})
+	now := time.Now()
+
+	if !p.take(now) || !p.take(now.Add(time.Second)) {
+		t.Fatalf("Expected first two runs to be allowed")
+	}
+	if p.take(now.Add(2 * time.Second)) {
+		t.Errorf("Expected third run within a minute to be rate limited")
+	}
+	if !p.take(now.Add(61 * time.Second)) {
+		t.Errorf("Expected run to be allowed once the window has passed")
+	}
+}
+
+// nextAdhocAck returns the next exec_adhoc_ack sent to the test server.
+func nextAdhocAck(t *testing.T, received <-chan []byte) protocol.ExecAdhocAckMessage {
+	t.Helper()
+	var ack protocol.ExecAdhocAckMessage
+	if err := json.Unmarshal(nextMessage(t, received), &ack); err != nil || ack.Type != "exec_adhoc_ack" {
+		t.Fatalf("message = %+v (%v), want exec_adhoc_ack", ack, err)
+	}
+	return ack
+}
+
+// waitForArgs returns the arguments the fake agent was last started with.
+func waitForArgs(t *testing.T, argsFile string) string {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, err := os.ReadFile(argsFile); err == nil {
+			os.Remove(argsFile)
+			return " " + strings.ReplaceAll(string(data), "\n", " ")
+		}
+	}
+	t.Fatal("exec mode was not started")
+	return ""
+}
+
+func TestExecAdhoc(t *testing.T) {
+	argsFile := fakeAgent(t)
+	bin := fakeAdhocPath(t, "df")
+	d := &daemon{adhoc: newAdhocPolicy(&Config{AdhocEnabled: true, AdhocAllowedCommands: []string{"df"}, AdhocRateLimitPerMinute: 3})}
+	received := connectTestServer(t, d)
+
+	// The resolved path is run, and the timeout is clamped.
+	d.execAdhoc("adhoc-1", []string{"df", "-h"}, 99999)
+	if ack := nextAdhocAck(t, received); ack.Status != "started" || ack.ExecutionID != "adhoc-1" {
+		t.Fatalf("ack = %+v, want started", ack)
+	}
+	args := waitForArgs(t, argsFile)
+	for _, want := range []string{" --timeout 600 ", " --trigger adhoc ", " --execution-id adhoc-1 ", " -- " + bin + "/df -h "} {
+		if !strings.Contains(args, want) {
+			t.Errorf("exec mode started with %q, missing %q", args, want)
+		}
+	}
+
+	// Without a timeout the default applies.
+	d.execAdhoc("adhoc-2", []string{"df"}, 0)
+	nextAdhocAck(t, received)
+	if args := waitForArgs(t, argsFile); !strings.Contains(args, " --timeout 60 ") {
+		t.Errorf("exec mode started with %q, want the default timeout", args)
+	}
+
+	d.execAdhoc("../escape", []string{"df"}, 0)
+	if ack := nextAdhocAck(t, received); ack.Status != "rejected" || ack.Reason != "invalid execution ID" {
+		t.Errorf("ack for an invalid execution ID = %+v, want rejected", ack)
+	}
+
+	d.execAdhoc("adhoc-3", []string{"df"}, 0)
+	nextAdhocAck(t, received)
+	d.execAdhoc("adhoc-4", []string{"df"}, 0)
+	if ack := nextAdhocAck(t, received); ack.Status != "rejected" || !strings.Contains(ack.Reason, "rate limit") {
+		t.Errorf("ack over the rate limit = %+v, want rejected", ack)
+	}
+}
+
+func TestExecAdhoc_RunAsNotAllowed(t *testing.T) {
+	fakeAgent(t)
+	fakeAdhocPath(t, "df")
+	d := &daemon{
+		executionMode: "system",
+		adhoc:         newAdhocPolicy(&Config{AdhocEnabled: true, AdhocAllowedCommands: []string{"df"}, AdhocRunAs: "deploy"}),
+	}
+	received := connectTestServer(t, d)
+
+	d.execAdhoc("adhoc-1", []string{"df"}, 0)
+	if ack := nextAdhocAck(t, received); ack.Status != "rejected" || !strings.Contains(ack.Reason, "allowed_run_as_users") {
+		t.Errorf("ack = %+v, want rejected for a runAs user that is not allowed", ack)
+	}
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Markers delimiting the block the agent manages in a user crontab. Entries
+// outside the block belong to the user and are never modified.
+const (
+	managedBlockBegin = "# BEGIN CronCommander managed block"
+	managedBlockEnd   = "# END CronCommander managed block"
+
+	// legacyCrontabHeader starts user crontabs written by agents that replaced
+	// the whole crontab. Such a crontab is managed in its entirety.
+	legacyCrontabHeader = "# CronCommander managed cron jobs\n"
+)
+
+// splitManagedBlock separates a user crontab into the unmanaged entries and
+// the content of the managed block (without markers). A block that is opened
+// but never closed is an error, so user entries are never mistaken for ours.
+func splitManagedBlock(crontab []byte) (rest, block []byte, err error) {
+	var restBuf, blockBuf bytes.Buffer
+	inBlock, found := false, false
+	for _, line := range bytes.SplitAfter(crontab, []byte("\n")) {
+		switch string(bytes.TrimSpace(line)) {
+		case managedBlockBegin:
+			if inBlock {
+				return nil, nil, fmt.Errorf("nested %q marker in crontab", managedBlockBegin)
+			}
+			inBlock, found = true, true
+			continue
+		case managedBlockEnd:
+			if !inBlock {
+				return nil, nil, fmt.Errorf("unexpected %q marker in crontab", managedBlockEnd)
+			}
+			inBlock = false
+			continue
+		}
+		if inBlock {
+			blockBuf.Write(line)
+		} else {
+			restBuf.Write(line)
+		}
+	}
+	if inBlock {
+		return nil, nil, fmt.Errorf("crontab has %q without %q", managedBlockBegin, managedBlockEnd)
+	}
+	if !found && bytes.HasPrefix(crontab, []byte(legacyCrontabHeader)) {
+		return nil, crontab, nil
+	}
+	return restBuf.Bytes(), blockBuf.Bytes(), nil
+}
+
+// mergeManagedBlock replaces the managed block in a user crontab with content.
+// The block is always placed last: it sets SHELL and PATH, which would
+// otherwise apply to the user's entries that follow it.
+func mergeManagedBlock(crontab, content []byte) ([]byte, error) {
+	rest, _, err := splitManagedBlock(crontab)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	rest = bytes.TrimRight(rest, "\n")
+	if len(bytes.TrimSpace(rest)) > 0 {
+		buf.Write(rest)
+		buf.WriteString("\n\n")
+	}
+	buf.WriteString(managedBlockBegin + "\n")
+	buf.Write(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(managedBlockEnd + "\n")
+	return buf.Bytes(), nil
+}
+
+// readUserCrontab returns the current user's crontab, or nothing if it has none.
+func readUserCrontab() ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("crontab", "-l")
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if bytes.Contains(stderr.Bytes(), []byte("no crontab")) {
+			return []byte{}, nil
+		}
+		return nil, fmt.Errorf("crontab -l: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return output, nil
+}
+
+// cronSupportsTZ reports whether the host's cron daemon honors CRON_TZ lines.
+// Of the common implementations only cronie does; Vixie cron, Debian's cron
+// and busybox crond ignore them and would run jobs in the host zone.
+func cronSupportsTZ() bool {
+	for _, name := range []string{"crond", "/usr/sbin/crond", "/sbin/crond"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		out, _ := exec.CommandContext(ctx, path, "-V").CombinedOutput()
+		cancel()
+		return bytes.Contains(bytes.ToLower(out), []byte("cronie"))
+	}
+	return false
+}
+
+// Files that name the host's time zone, variables so tests can replace them.
+var (
+	localtimePath = "/etc/localtime"
+	timezonePath  = "/etc/timezone"
+)
+
+// hostTimezone returns the IANA name of the zone cron uses for entries without
+// CRON_TZ, or "" if it cannot be named. Without /etc/localtime the C library
+// uses UTC.
+func hostTimezone() string {
+	if target, err := os.Readlink(localtimePath); err == nil {
+		if _, name, ok := strings.Cut(filepath.ToSlash(target), "zoneinfo/"); ok && name != "" {
+			return name
+		}
+	} else if os.IsNotExist(err) {
+		return "UTC"
+	}
+	// A copied zone file carries no name; Debian also records it here.
+	if data, err := os.ReadFile(timezonePath); err == nil {
+		if name := strings.TrimSpace(string(data)); name != "" && !containsNewline(name) {
+			return name
+		}
+	}
+	return ""
+}
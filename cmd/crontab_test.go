@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeManagedBlock_PreservesUserEntries(t *testing.T) {
+	existing := "MAILTO=me@example.com\n" +
+		"@daily /home/me/backup.sh\n" +
+		managedBlockBegin + "\n" +
+		"0 * * * * old-job\n" +
+		managedBlockEnd + "\n" +
+		"30 2 * * * /home/me/report.sh\n"
+
+	merged, err := mergeManagedBlock([]byte(existing), []byte("SHELL=/bin/bash\n5 * * * * new-job\n"))
+	if err != nil {
+		t.Fatalf("mergeManagedBlock failed: %v", err)
+	}
+	out := string(merged)
+
+	for _, keep := range []string{"MAILTO=me@example.com", "@daily /home/me/backup.sh", "30 2 * * * /home/me/report.sh", "new-job"} {
+		if !strings.Contains(out, keep) {
+			t.Errorf("merged crontab is missing %q:\n%s", keep, out)
+		}
+	}
+	if strings.Contains(out, "old-job") {
+		t.Errorf("old managed entries were not replaced:\n%s", out)
+	}
+	// The block goes last so its SHELL/PATH do not apply to user entries.
+	if !strings.HasSuffix(out, "new-job\n"+managedBlockEnd+"\n") || strings.Index(out, "report.sh") > strings.Index(out, managedBlockBegin) {
+		t.Errorf("managed block is not at the end:\n%s", out)
+	}
+
+	// Merging again is stable.
+	again, _ := mergeManagedBlock(merged, []byte("SHELL=/bin/bash\n5 * * * * new-job\n"))
+	if string(again) != out {
+		t.Errorf("merge is not idempotent:\n%s\nvs\n%s", again, out)
+	}
+}
+
+func TestMergeManagedBlock_RejectsUnterminatedBlock(t *testing.T) {
+	existing := managedBlockBegin + "\n0 * * * * job\n@daily /home/me/backup.sh\n"
+	if _, err := mergeManagedBlock([]byte(existing), []byte("x\n")); err == nil {
+		t.Errorf("Expected an unterminated block to be rejected")
+	}
+}
+
+func TestMergeManagedBlock_MigratesLegacyCrontab(t *testing.T) {
+	legacy := generateCronContent(nil, false)
+	merged, err := mergeManagedBlock(legacy, []byte("5 * * * * new-job\n"))
+	if err != nil {
+		t.Fatalf("mergeManagedBlock failed: %v", err)
+	}
+	if !strings.HasPrefix(string(merged), managedBlockBegin) {
+		t.Errorf("legacy agent crontab was kept as user entries:\n%s", merged)
+	}
+}
+
+func TestHostTimezone(t *testing.T) {
+	defer func(l, tz string) { localtimePath, timezonePath = l, tz }(localtimePath, timezonePath)
+	dir := t.TempDir()
+	localtimePath = filepath.Join(dir, "localtime")
+	timezonePath = filepath.Join(dir, "timezone")
+
+	if got := hostTimezone(); got != "UTC" {
+		t.Errorf("hostTimezone() without /etc/localtime = %q, want UTC", got)
+	}
+
+	os.WriteFile(localtimePath, []byte("TZif"), 0644)
+	if got := hostTimezone(); got != "" {
+		t.Errorf("hostTimezone() for an unnamed zone file = %q, want empty", got)
+	}
+	os.WriteFile(timezonePath, []byte("Europe/Berlin\n"), 0644)
+	if got := hostTimezone(); got != "Europe/Berlin" {
+		t.Errorf("hostTimezone() from /etc/timezone = %q, want Europe/Berlin", got)
+	}
+
+	os.Remove(localtimePath)
+	os.Symlink("../usr/share/zoneinfo/Asia/Tokyo", localtimePath)
+	if got := hostTimezone(); got != "Asia/Tokyo" {
+		t.Errorf("hostTimezone() from symlink = %q, want Asia/Tokyo", got)
+	}
+}
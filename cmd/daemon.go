@@ -12,12 +12,16 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/croncommander/cc-agent/internal/cronexpr"
 	"github.com/croncommander/cc-agent/internal/protocol"
 	"github.com/gorilla/websocket"
 	"github.com/spf13/cobra"
@@ -29,8 +33,15 @@ const (
 	secureSocketDir   = "/var/lib/croncommander"
 	cronFilePath      = "/etc/cron.d/croncommander"
 	heartbeatInterval = 60 * time.Second
+	// maxReportSize bounds a single execution report read from the socket or spool.
+	// 1MB is sufficient for legitimate reports (256KB stdout + 256KB stderr + metadata).
+	maxReportSize     = 1024 * 1024
 	reconnectDelay    = 5 * time.Second
 	maxReconnectDelay = 60 * time.Second
+	// reportAckTimeout is how long a sent report may stay unacknowledged before
+	// it is retransmitted. The server dedupes retransmissions by execution ID.
+	reportAckTimeout = 2 * time.Minute
+	ackCheckInterval = 30 * time.Second
 )
 
 var (
@@ -39,6 +50,8 @@ var (
 	daemonConfigFile string
 	// socketPath is determined at runtime to support both prod (secure) and dev (tmp) environments.
 	socketPath = getSocketPath()
+	// stateDir holds durable agent state such as the report outbox.
+	stateDir = getStateDir()
 	// socketReadTimeout prevents Slowloris-style DoS attacks on the unix socket.
 	// It is a variable to allow overriding in tests.
 	socketReadTimeout = 5 * time.Second
@@ -77,6 +90,15 @@ func getSocketPath() string {
 	return filepath.Join(os.TempDir(), "cc-agent-"+os.Getenv("USER")+".sock")
 }
 
+// getStateDir determines the directory for durable agent state.
+// In System Mode (root), this is the global secure dir; otherwise the user's home.
+func getStateDir() string {
+	if os.Geteuid() == 0 {
+		return secureSocketDir
+	}
+	return filepath.Join(os.Getenv("HOME"), ".croncommander")
+}
+
 // getSocketPathWithBase returns the socket path within the given base directory.
 // This is primarily exposed for testing to verify path construction logic.
 func getSocketPathWithBase(baseDir string) string {
@@ -88,6 +110,45 @@ type Config struct {
 	ApiKey        string `yaml:"api_key"`
 	ServerURL     string `yaml:"server_url"`
 	ExecutionMode string `yaml:"execution_mode"` // "user" (default) or "system"
+	StateDir      string `yaml:"state_dir"`      // Overrides the default state directory
+
+	// AllowedRunAsUsers lists the local users jobs may run as in system mode.
+	// Jobs targeting any other user (except root) are rejected.
+	AllowedRunAsUsers []string `yaml:"allowed_run_as_users"`
+
+	// RedactPatterns are regular expressions replaced in job output before reports
+	// leave the host, in addition to built-in patterns for common credentials.
+	RedactPatterns []string `yaml:"redact_patterns"`
+
+	// SecretsKeyFile keeps the secret store's key outside the state directory,
+	// so that a copy of the state directory alone does not reveal secrets.
+	SecretsKeyFile string `yaml:"secrets_key_file"`
+
+	// Outbox caps bound the disk used by reports awaiting server acknowledgement.
+	OutboxMaxSizeMB   int `yaml:"outbox_max_size_mb"`   // Default 100
+	OutboxMaxAgeHours int `yaml:"outbox_max_age_hours"` // Default 168 (7 days)
+
+	// Scheduler selects how jobs are scheduled: "cron" (default), "systemd"
+	// timer units, or "internal" to run them from the daemon itself, for hosts
+	// without a cron daemon.
+	Scheduler string `yaml:"scheduler"`
+
+	// JobDiscovery makes the daemon report existing, unmanaged cron entries to
+	// the server after registering. Off by default, since it sends commands
+	// the server did not create.
+	JobDiscovery bool `yaml:"job_discovery"`
+
+	// DriftAction is what to do when the installed cron table no longer matches
+	// what the daemon wrote: "report" (default) or "repair" (report and rewrite).
+	DriftAction string `yaml:"drift_action"`
+
+	// Ad-hoc commands (exec_adhoc) are disabled unless explicitly enabled.
+	// AdhocAllowedCommands optionally restricts them to argv prefixes such as
+	// "systemctl status". In system mode they run as AdhocRunAs (default root).
+	AdhocEnabled            bool     `yaml:"adhoc_enabled"`
+	AdhocAllowedCommands    []string `yaml:"adhoc_allowed_commands"`
+	AdhocRateLimitPerMinute int      `yaml:"adhoc_rate_limit_per_minute"` // Default 6
+	AdhocRunAs              string   `yaml:"adhoc_run_as"`
 }
 
 func runDaemon(cmd *cobra.Command, args []string) {
@@ -108,6 +169,10 @@ func runDaemon(cmd *cobra.Command, args []string) {
 		if config.ExecutionMode != "" {
 			executionMode = config.ExecutionMode
 		}
+		if config.StateDir != "" {
+			stateDir = config.StateDir
+		}
+		secretsKeyPath = config.SecretsKeyFile
 	}
 
 	if apiKey == "" {
@@ -120,9 +185,18 @@ func runDaemon(cmd *cobra.Command, args []string) {
 		log.Fatal("Execution mode 'system' requires root privileges. Please run as root or switch to 'user' mode.")
 	}
 
+	scheduler := schedulerCron
+	if config != nil && config.Scheduler != "" {
+		scheduler = config.Scheduler
+	}
+	if scheduler != schedulerCron && scheduler != schedulerSystemd && scheduler != schedulerInternal {
+		log.Fatalf("Unknown scheduler %q. Use 'cron', 'systemd' or 'internal'.", scheduler)
+	}
+
 	log.Printf("CronCommander Agent starting...")
 	log.Printf("Server: %s", serverURL)
 	log.Printf("Mode: %s (Root: %v)", executionMode, isRoot)
+	log.Printf("Scheduler: %s", scheduler)
 
 	// Create daemon instance
 	d := &daemon{
@@ -132,11 +206,63 @@ func runDaemon(cmd *cobra.Command, args []string) {
 		osType:        getOsInfo(),
 		executionMode: executionMode,
 		isRoot:        isRoot,
+		scheduler:     scheduler,
+		adhoc:         newAdhocPolicy(config),
+		driftAction:   driftActionReport,
+		discoverJobs:  config != nil && config.JobDiscovery,
+	}
+	if scheduler == schedulerCron {
+		d.cronTZ = cronSupportsTZ()
+	}
+	if config != nil {
+		switch config.DriftAction {
+		case "", driftActionReport:
+		case driftActionRepair:
+			d.driftAction = driftActionRepair
+		default:
+			log.Printf("Warning: unknown drift_action %q, defaulting to %q", config.DriftAction, driftActionReport)
+		}
+		d.allowedRunAs = config.AllowedRunAsUsers
+		d.localRedactPatterns = compileRedactPatterns(config.RedactPatterns)
+	}
+
+	// Open the durable outbox so reports survive outages and restarts.
+	var outboxMaxBytes int64
+	var outboxMaxAge time.Duration
+	if config != nil {
+		outboxMaxBytes = int64(config.OutboxMaxSizeMB) * 1024 * 1024
+		outboxMaxAge = time.Duration(config.OutboxMaxAgeHours) * time.Hour
+	}
+	ob, err := newOutbox(filepath.Join(stateDir, "outbox"), outboxMaxBytes, outboxMaxAge)
+	if err != nil {
+		log.Printf("Warning: %v. Execution reports will not be persisted.", err)
+	} else {
+		d.outbox = ob
+	}
+
+	// Secret values are redacted from reports before the server syncs them again.
+	if secrets, err := loadSecrets(stateDir); err != nil {
+		log.Printf("Warning: failed to load secret store: %v", err)
+	} else {
+		d.setSecretValues(secrets)
+	}
+
+	// Installed jobs can be run on demand before the server syncs them again.
+	if jobs, err := loadSyncedJobs(stateDir); err != nil {
+		log.Printf("Warning: failed to load installed jobs: %v", err)
+	} else {
+		d.jobs = jobs
 	}
 
 	// Start Unix socket listener for exec mode reports
 	go d.startSocketListener()
 
+	// Forward reports that exec mode spooled while the socket was unreachable
+	go d.watchSpool(spoolDir(stateDir))
+
+	// Detect hand edits of the installed cron table
+	go d.watchDrift()
+
 	// Handle shutdown gracefully
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -233,10 +359,56 @@ type daemon struct {
 	osType        string
 	executionMode string
 	isRoot        bool
+	allowedRunAs  []string
+	scheduler     string
+	cronTZ        bool // Whether the host's cron honors CRON_TZ
+	adhoc         *adhocPolicy
+	discoverJobs  bool
 	agentID       string
 	conn          *websocket.Conn
 	connMu        sync.Mutex
 	shutdown      func()
+
+	// jobs are the validated jobs from the latest sync_jobs, used for run_job.
+	jobs   []protocol.JobDefinition
+	jobsMu sync.Mutex
+
+	// internal is the in-process scheduler, started on first use.
+	internal     *internalScheduler
+	internalOnce sync.Once
+
+	// cronContent is the cron content last installed by the daemon, and
+	// driftHash the hash of drifted content already reported to the server.
+	cronContent []byte
+	driftHash   string
+	driftAction string
+	cronMu      sync.Mutex
+
+	// discoveredSum is the hash of the last discovered_jobs sent, so unchanged
+	// entries are not re-sent on every reconnect.
+	discoveredSum string
+	discoverMu    sync.Mutex
+
+	// streams are the running executions with a live exec session, by execution ID.
+	streams   map[string]*execStream
+	streamsMu sync.Mutex
+
+	// Redaction patterns from the local config and from the latest sync_jobs,
+	// and the values of the local secret store.
+	localRedactPatterns  []*regexp.Regexp
+	serverRedactPatterns []*regexp.Regexp
+	secretValues         []string
+	redactMu             sync.Mutex
+
+	// outbox persists execution reports until the server acknowledges them.
+	// outboxMu serializes replay so reports are sent in order, and guards
+	// registered and inflight, which are reset on every new connection.
+	// inflight maps the execution ID of each unacknowledged report sent on the
+	// current connection to the time it was sent.
+	outbox     *outbox
+	outboxMu   sync.Mutex
+	registered bool
+	inflight   map[string]time.Time
 }
 
 func (d *daemon) run() {
@@ -284,6 +456,12 @@ func (d *daemon) connect() error {
 	d.conn = conn
 	d.connMu.Unlock()
 
+	// Nothing has been sent on this connection yet; replay starts after register_ack.
+	d.outboxMu.Lock()
+	d.registered = false
+	d.inflight = make(map[string]time.Time)
+	d.outboxMu.Unlock()
+
 	// Send registration
 	regMsg := protocol.RegisterMessage{
 		Type:          "register",
@@ -308,6 +486,9 @@ func (d *daemon) messageLoop() {
 	heartbeatTicker := time.NewTicker(heartbeatInterval)
 	defer heartbeatTicker.Stop()
 
+	ackTicker := time.NewTicker(ackCheckInterval)
+	defer ackTicker.Stop()
+
 	stopHeartbeat := make(chan struct{})
 	defer close(stopHeartbeat)
 
@@ -319,6 +500,8 @@ func (d *daemon) messageLoop() {
 					log.Printf("Failed to send heartbeat: %v", err)
 					return
 				}
+			case <-ackTicker.C:
+				d.retransmitUnacked()
 			case <-stopHeartbeat:
 				return
 			}
@@ -349,6 +532,14 @@ func (d *daemon) handleMessage(data []byte) {
 		if msg.Status == "success" {
 			d.agentID = msg.AgentID
 			log.Printf("Registration successful. Agent ID: %s", d.agentID)
+
+			d.outboxMu.Lock()
+			d.registered = true
+			d.outboxMu.Unlock()
+			go d.flushOutbox()
+			if d.discoverJobs {
+				go d.sendDiscoveredJobs()
+			}
 		} else {
 			log.Printf("Registration failed: %s", msg.Reason)
 		}
@@ -356,10 +547,29 @@ func (d *daemon) handleMessage(data []byte) {
 	case "heartbeat_ack":
 		log.Println("Heartbeat acknowledged")
 
+	case "report_ack":
+		d.ackReport(msg.ExecutionID)
+
 	case "sync_jobs":
 		log.Printf("Received sync_jobs with %d jobs", len(msg.Jobs))
+		d.setServerRedactPatterns(msg.RedactPatterns)
 		d.syncCron(msg.Jobs)
 
+	case "run_job":
+		log.Printf("Received run_job for job %s", msg.JobID)
+		d.runJob(msg.JobID, msg.ExecutionID)
+
+	case "exec_adhoc":
+		d.execAdhoc(msg.ExecutionID, msg.Argv, msg.TimeoutSeconds)
+
+	case "cancel_execution":
+		d.cancelExecution(msg.ExecutionID, msg.CancelledBy)
+
+	case "sync_secrets":
+		// Never log secret values, only their count.
+		log.Printf("Received sync_secrets with %d secrets", len(msg.Secrets))
+		d.syncSecrets(msg.Secrets)
+
 	case "error":
 		log.Printf("Server error: %s", msg.Reason)
 
@@ -368,44 +578,318 @@ func (d *daemon) handleMessage(data []byte) {
 	}
 }
 
+// syncCron validates and installs jobs from a sync_jobs. The server gets two
+// replies: sync_result right after validation, listing accepted and rejected
+// jobs, and sync_ack once the jobs are installed. Installation can block on
+// crontab or systemctl, so the per-job verdict is not held back for it; the
+// ack repeats the rejections so it is complete on its own.
 func (d *daemon) syncCron(jobs []protocol.JobDefinition) {
+	jobs, rejected := d.validateJobs(jobs)
+	d.sendSyncResult(jobs, rejected)
+
+	ack := protocol.SyncAckMessage{Type: "sync_ack", Skipped: rejected}
+	if ack.Skipped == nil {
+		ack.Skipped = []protocol.JobRejection{}
+	}
+
+	content, output, err := d.installJobs(jobs)
+	ack.CrontabOutput = output
+	if err != nil {
+		ack.Outcome = "failed"
+		ack.Error = err.Error()
+	} else {
+		ack.Outcome = "applied"
+		ack.Installed = len(jobs)
+		ack.ContentHash = contentHash(content)
+	}
+
+	if err := d.sendMessage(ack); err != nil {
+		log.Printf("Failed to send sync_ack: %v", err)
+	}
+}
+
+// installJobs installs validated jobs and, once they are installed, makes them
+// available to run_job. It returns the installed cron content and any output
+// from crontab.
+func (d *daemon) installJobs(jobs []protocol.JobDefinition) ([]byte, string, error) {
+	content, output, err := d.installSchedule(jobs)
+	if err != nil {
+		return nil, output, err
+	}
+	d.setJobs(jobs)
+	return content, output, nil
+}
+
+// installSchedule writes spec files and the cron table (or the configured
+// backend's equivalent) for validated jobs.
+func (d *daemon) installSchedule(jobs []protocol.JobDefinition) ([]byte, string, error) {
+	// Spec files must exist before cron can start jobs that reference them.
+	if err := writeJobSpecs(stateDir, jobs); err != nil {
+		log.Printf("Failed to write job specs: %v", err)
+		return nil, "", fmt.Errorf("failed to write job specs: %w", err)
+	}
+
+	// Entries left from another backend would run jobs twice.
+	if d.scheduler != schedulerCron && d.scheduler != "" {
+		if err := d.removeCron(); err != nil {
+			log.Printf("Failed to remove cron entries: %v", err)
+		}
+	}
+	if d.scheduler != schedulerSystemd {
+		if err := d.removeSystemdUnits(); err != nil {
+			log.Printf("Failed to remove systemd units: %v", err)
+		}
+	}
+
+	switch d.scheduler {
+	case schedulerSystemd:
+		return d.syncSystemdUnits(jobs)
+	case schedulerInternal:
+		summary, err := d.syncInternalJobs(jobs)
+		return summary, "", err
+	}
+
+	content := generateCronContent(jobs, d.executionMode == "system")
+
+	d.cronMu.Lock()
+	defer d.cronMu.Unlock()
+	output, err := d.writeCron(content)
+	if err != nil {
+		return nil, output, err
+	}
+	log.Printf("%s updated with %d jobs", d.cronTarget(), len(jobs))
+
+	// Remember what was installed so drift can be detected.
+	d.cronContent = content
+	d.driftHash = ""
+	return content, output, nil
+}
+
+// writeCron installs cron content for the current execution mode.
+func (d *daemon) writeCron(content []byte) (string, error) {
+	if d.executionMode == "system" {
+		return "", d.syncSystemCron(content)
+	}
+	return d.syncUserCron(content)
+}
+
+// removeCron removes the agent's cron entries, if any.
+func (d *daemon) removeCron() error {
+	d.cronMu.Lock()
+	defer d.cronMu.Unlock()
+	d.cronContent = nil
+
+	if d.executionMode == "system" {
+		if err := os.Remove(cronFilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath("crontab"); err != nil {
+		// No cron on this host, so there is nothing to remove.
+		return nil
+	}
+	current, err := readUserCrontab()
+	if err != nil {
+		return err
+	}
+	rest, block, err := splitManagedBlock(current)
+	if err != nil || len(block) == 0 {
+		return err
+	}
+	cmd := exec.Command("crontab", "-")
+	cmd.Stdin = bytes.NewReader(rest)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("crontab -: %v: %s", err, output)
+	}
+	return nil
+}
+
+// cronTarget describes where jobs are installed, for logs and server messages.
+func (d *daemon) cronTarget() string {
 	if d.executionMode == "system" {
-		d.syncSystemCron(jobs)
+		return cronFilePath
+	}
+	return "user crontab"
+}
+
+// sendSyncResult tells the server which jobs were accepted and which were
+// rejected, and why.
+func (d *daemon) sendSyncResult(accepted []protocol.JobDefinition, rejected []protocol.JobRejection) {
+	msg := protocol.SyncResultMessage{
+		Type:     "sync_result",
+		Accepted: make([]string, 0, len(accepted)),
+		Rejected: rejected,
+	}
+	for _, job := range accepted {
+		msg.Accepted = append(msg.Accepted, job.JobID)
+	}
+	if msg.Rejected == nil {
+		msg.Rejected = []protocol.JobRejection{}
+	}
+	if err := d.sendMessage(msg); err != nil {
+		log.Printf("Failed to send sync_result: %v", err)
+	}
+}
+
+// syncSecrets replaces the local encrypted secret store. Secrets shorter than
+// minRedactLength are rejected: they could not be redacted from job output
+// without mangling it, so they would reach the server in plain text.
+func (d *daemon) syncSecrets(secrets map[string]string) {
+	for name, value := range secrets {
+		if !secretNamePattern.MatchString(name) {
+			log.Printf("Rejecting sync_secrets: invalid secret name %q", name)
+			return
+		}
+		if len(value) < minRedactLength {
+			log.Printf("Rejecting sync_secrets: secret %q is shorter than %d bytes and cannot be redacted", name, minRedactLength)
+			return
+		}
+	}
+	if err := saveSecrets(stateDir, secrets); err != nil {
+		log.Printf("Failed to update secret store: %v", err)
+		return
+	}
+	d.setSecretValues(secrets)
+	log.Printf("Secret store updated with %d secrets", len(secrets))
+}
+
+// setSecretValues makes the daemon redact secret values from everything it
+// forwards, in addition to the redaction exec mode applies to injected ones.
+func (d *daemon) setSecretValues(secrets map[string]string) {
+	var r redactor
+	for _, value := range secrets {
+		r.addValues(value)
+	}
+	d.redactMu.Lock()
+	d.secretValues = r.values
+	d.redactMu.Unlock()
+}
+
+// validateJobs drops jobs that cannot be installed safely on this host and
+// normalizes cron expressions. It returns the valid jobs and the reasons the
+// others were rejected.
+func (d *daemon) validateJobs(jobs []protocol.JobDefinition) ([]protocol.JobDefinition, []protocol.JobRejection) {
+	valid := make([]protocol.JobDefinition, 0, len(jobs))
+	var rejected []protocol.JobRejection
+	for _, job := range jobs {
+		if err := d.validateJob(&job); err != nil {
+			log.Printf("Skipping job %q: %v", job.JobID, err)
+			rejected = append(rejected, protocol.JobRejection{JobID: job.JobID, Reason: err.Error()})
+			continue
+		}
+		valid = append(valid, job)
+	}
+	return valid, rejected
+}
+
+func (d *daemon) validateJob(job *protocol.JobDefinition) error {
+	if containsNewline(job.CronExpression) || containsNewline(job.JobID) || containsNewline(job.Command) {
+		return fmt.Errorf("contains invalid characters")
+	}
+	if cronexpr.IsReboot(job.CronExpression) {
+		// Only cron itself knows when it started; other backends cannot run it.
+		if d.scheduler != schedulerCron && d.scheduler != "" {
+			return fmt.Errorf("%s is only supported by the cron scheduler, not %q", cronexpr.Reboot, d.scheduler)
+		}
+		job.CronExpression = cronexpr.Reboot
 	} else {
-		d.syncUserCron(jobs)
+		schedule, err := cronexpr.Parse(job.CronExpression)
+		if err != nil {
+			return fmt.Errorf("invalid cron expression: %w", err)
+		}
+		job.CronExpression = schedule.String()
+	}
+	if err := d.validateTimezone(job.Timezone); err != nil {
+		return err
+	}
+	if !validConcurrencyPolicy(job.ConcurrencyPolicy) {
+		return fmt.Errorf("unknown concurrency policy %q", job.ConcurrencyPolicy)
+	}
+	if job.JitterSeconds < 0 || job.JitterSeconds > maxJitterSeconds {
+		return fmt.Errorf("jitterSeconds must be between 0 and %d", maxJitterSeconds)
+	}
+	if job.Retries < 0 || job.Retries > maxRetries {
+		return fmt.Errorf("retries must be between 0 and %d", maxRetries)
+	}
+	if job.RetryDelaySeconds < 0 || job.RetryDelaySeconds > maxRetryDelaySeconds {
+		return fmt.Errorf("retryDelaySeconds must be between 0 and %d", maxRetryDelaySeconds)
+	}
+	for _, code := range job.RetryOnExitCodes {
+		if code < 1 || code > 255 {
+			return fmt.Errorf("invalid exit code %d in retryOnExitCodes", code)
+		}
+	}
+	if err := validateLimits(job.Limits); err != nil {
+		return err
 	}
+	if err := validateRunAs(job.RunAs, job.RunAsGroup, d.executionMode == "system", d.allowedRunAs); err != nil {
+		return err
+	}
+	// The kernel does not apply RLIMIT_NPROC to root, so the limit would be a no-op.
+	if job.Limits != nil && job.Limits.Processes != nil && (job.RunAs == "root" || job.RunAs == "" && d.isRoot) {
+		return fmt.Errorf("limits.processes is not enforced for jobs running as root")
+	}
+	return validateJobSpec(*job)
 }
 
-func (d *daemon) syncSystemCron(jobs []protocol.JobDefinition) {
-	content := generateCronContent(jobs, true)
+// validateTimezone checks that tz is empty or an IANA zone name, and that the
+// scheduler can run jobs in it.
+func (d *daemon) validateTimezone(tz string) error {
+	if tz == "" {
+		return nil
+	}
+	if tz == "Local" || containsNewline(tz) {
+		return fmt.Errorf("invalid timezone %q", tz)
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("invalid timezone %q: %w", tz, err)
+	}
+	if (d.scheduler == schedulerCron || d.scheduler == "") && !d.cronTZ {
+		return fmt.Errorf("timezone %q not supported: cron on this host does not honor CRON_TZ", tz)
+	}
+	return nil
+}
 
+func (d *daemon) syncSystemCron(content []byte) error {
 	// Write atomically to /etc/cron.d/croncommander
 	tmpFile := cronFilePath + ".tmp"
 	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
 		log.Printf("Failed to write cron file: %v", err)
-		return
+		return fmt.Errorf("failed to write cron file: %w", err)
 	}
 
 	if err := os.Rename(tmpFile, cronFilePath); err != nil {
 		log.Printf("Failed to rename cron file: %v", err)
 		os.Remove(tmpFile)
-		return
+		return fmt.Errorf("failed to rename cron file: %w", err)
 	}
-	log.Printf("System cron file updated with %d jobs", len(jobs))
+	return nil
 }
 
-func (d *daemon) syncUserCron(jobs []protocol.JobDefinition) {
-	content := generateCronContent(jobs, false)
+func (d *daemon) syncUserCron(content []byte) (string, error) {
+	// Replace only the managed block, keeping the user's own entries.
+	current, err := readUserCrontab()
+	if err != nil {
+		log.Printf("Failed to read user crontab: %v", err)
+		return "", err
+	}
+	merged, err := mergeManagedBlock(current, content)
+	if err != nil {
+		log.Printf("Refusing to update user crontab: %v", err)
+		return "", err
+	}
 
 	// Use 'crontab -' to install
 	cmd := exec.Command("crontab", "-")
-	cmd.Stdin = bytes.NewReader(content)
+	cmd.Stdin = bytes.NewReader(merged)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		log.Printf("Failed to update user crontab: %v. Output: %s", err, output)
-		return
+		return string(output), fmt.Errorf("failed to update user crontab: %w", err)
 	}
-	log.Printf("User crontab updated with %d jobs", len(jobs))
+	return string(output), nil
 }
 
 func generateCronContent(jobs []protocol.JobDefinition, systemMode bool) []byte {
@@ -417,11 +901,33 @@ func generateCronContent(jobs []protocol.JobDefinition, systemMode bool) []byte
 	buf.WriteString("SHELL=/bin/bash\n")
 	buf.WriteString("PATH=/usr/local/bin:/usr/bin:/bin\n\n")
 
+	// CRON_TZ applies to every entry after it, so jobs in the host zone come
+	// first and the rest are grouped by zone.
+	jobs = append([]protocol.JobDefinition(nil), jobs...)
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Timezone < jobs[j].Timezone })
+
+	// In user mode the managed block follows the user's own entries, which may
+	// set CRON_TZ themselves. Reset it to the host zone for host-zone jobs.
+	timezone := ""
+	if !systemMode && len(jobs) > 0 && jobs[0].Timezone == "" {
+		if host := hostTimezone(); host != "" {
+			buf.WriteString("CRON_TZ=" + host + "\n")
+		}
+	}
 	for _, job := range jobs {
-		if containsNewline(job.CronExpression) || containsNewline(job.JobID) || containsNewline(job.Command) {
+		if containsNewline(job.CronExpression) || containsNewline(job.JobID) || containsNewline(job.Command) || containsNewline(job.Timezone) {
 			log.Printf("Skipping job %q: contains invalid characters", job.JobID)
 			continue
 		}
+		if !validConcurrencyPolicy(job.ConcurrencyPolicy) {
+			log.Printf("Skipping job %q: unknown concurrency policy %q", job.JobID, job.ConcurrencyPolicy)
+			continue
+		}
+
+		if job.Timezone != timezone {
+			timezone = job.Timezone
+			buf.WriteString("\nCRON_TZ=" + timezone + "\n")
+		}
 
 		// User mode: <cron> command
 		// System mode: <cron> <user> command
@@ -430,36 +936,143 @@ func generateCronContent(jobs []protocol.JobDefinition, systemMode bool) []byte
 		buf.WriteByte(' ')
 
 		if systemMode {
-			// In system mode, run jobs as root (for this MVP) since we don't have per-job user config.
+			// In system mode, cron starts the wrapper as root. The wrapper drops
+			// privileges to the job's runAs user for the command itself, while it
+			// keeps access to the daemon socket, spool and lock files.
 			buf.WriteString("root ")
 		}
 
-		// Self-executable path
-		execPath, err := os.Executable()
-		if err != nil {
-			execPath = "/usr/local/bin/cc-agent"
-		}
+		buf.WriteString(agentExecutable())
+		writeCronArgs(&buf, execArgs(job))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
 
-		buf.WriteString(execPath)
-		buf.WriteString(" exec --job-id ")
-		writeShellQuote(&buf, job.JobID)
+// agentExecutable returns the path used to start exec mode. It is a variable
+// so tests can start a stub instead.
+var agentExecutable = func() string {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "/usr/local/bin/cc-agent"
+	}
+	return execPath
+}
 
-		// Always pass the socket path explicitly to ensure the job finds the daemon
-		// regardless of the user execution context (e.g. non-root job -> root daemon).
-		buf.WriteString(" --socket-path ")
-		writeShellQuote(&buf, socketPath)
+// execArgs returns the arguments that run job through exec mode. Cron entries
+// and manual runs use the same arguments, so both get the same environment
+// and reporting path. Extra flags are placed before the command.
+func execArgs(job protocol.JobDefinition, extra ...string) []string {
+	args := []string{"exec", "--job-id", job.JobID}
 
-		buf.WriteString(" -- /bin/sh -c ")
-		writeShellQuote(&buf, job.Command)
-		buf.WriteByte('\n')
+	// Always pass the socket path explicitly to ensure the job finds the daemon
+	// regardless of the user execution context (e.g. non-root job -> root daemon).
+	args = append(args, "--socket-path", socketPath)
+
+	// The state directory holds the spool exec falls back to if the socket is down.
+	args = append(args, "--state-dir", stateDir)
+
+	if job.TimeoutSeconds > 0 {
+		args = append(args, "--timeout", strconv.Itoa(job.TimeoutSeconds))
 	}
-	return buf.Bytes()
+
+	if len(job.Secrets) > 0 && secretsKeyPath != "" {
+		args = append(args, "--secrets-key", secretsKeyPath)
+	}
+
+	if jobNeedsSpec(job) {
+		// Environment and working directory are read from a private spec file
+		// so that their values never appear in the cron file.
+		args = append(args, "--spec", jobSpecPath(stateDir, job.JobID))
+	}
+
+	if job.RunAs != "" {
+		args = append(args, "--run-as", job.RunAs)
+	}
+	if job.RunAsGroup != "" {
+		args = append(args, "--run-as-group", job.RunAsGroup)
+	}
+
+	if job.ConcurrencyPolicy != "" && job.ConcurrencyPolicy != concurrencyAllow {
+		args = append(args, "--concurrency-policy", job.ConcurrencyPolicy)
+	}
+
+	if job.JitterSeconds > 0 {
+		args = append(args, "--jitter", strconv.Itoa(job.JitterSeconds))
+	}
+
+	if job.Retries > 0 {
+		args = append(args, "--retries", strconv.Itoa(job.Retries))
+		if job.RetryDelaySeconds > 0 {
+			args = append(args, "--retry-delay", strconv.Itoa(job.RetryDelaySeconds))
+		}
+		if len(job.RetryOnExitCodes) > 0 {
+			codes := make([]string, len(job.RetryOnExitCodes))
+			for i, code := range job.RetryOnExitCodes {
+				codes[i] = strconv.Itoa(code)
+			}
+			args = append(args, "--retry-on-exit-codes", strings.Join(codes, ","))
+		}
+	}
+
+	if limits := formatLimits(job.Limits); limits != "" {
+		args = append(args, "--limits", limits)
+	}
+
+	args = append(args, extra...)
+	return append(args, "--", "/bin/sh", "-c", job.Command)
 }
 
 func containsNewline(s string) bool {
 	return strings.ContainsAny(s, "\n\r")
 }
 
+// bareFlagValues are the exec flags whose values are numbers or fixed words
+// and are written to cron entries unquoted.
+var bareFlagValues = map[string]bool{
+	"--timeout":             true,
+	"--concurrency-policy":  true,
+	"--jitter":              true,
+	"--retries":             true,
+	"--retry-delay":         true,
+	"--retry-on-exit-codes": true,
+	"--limits":              true,
+}
+
+// bareValue matches the values written unquoted. '%' is excluded because cron
+// treats it specially.
+var bareValue = regexp.MustCompile(`^[A-Za-z0-9,=]+$`)
+
+// writeCronArgs writes exec arguments (see execArgs) to a cron entry in the
+// agent's established format: flag names, numbers and "/bin/sh -c" as-is, and
+// every other value, including the job's command, single-quoted.
+func writeCronArgs(buf *bytes.Buffer, args []string) {
+	buf.WriteByte(' ')
+	buf.WriteString(args[0]) // "exec"
+
+	// Every flag takes exactly one value.
+	i := 1
+	for ; i+1 < len(args) && args[i] != "--"; i += 2 {
+		flag, value := args[i], args[i+1]
+		buf.WriteString(" " + flag + " ")
+		if bareFlagValues[flag] && bareValue.MatchString(value) {
+			buf.WriteString(value)
+		} else {
+			writeShellQuote(buf, value)
+		}
+	}
+
+	buf.WriteString(" --")
+	for _, arg := range args[i+1:] {
+		buf.WriteByte(' ')
+		if arg == "/bin/sh" || arg == "-c" {
+			buf.WriteString(arg)
+		} else {
+			writeShellQuote(buf, arg)
+		}
+	}
+}
+
 func writeShellQuote(buf *bytes.Buffer, s string) {
 	if s == "" {
 		buf.WriteString("''")
@@ -536,7 +1149,10 @@ func (d *daemon) startSocketListener() {
 func (d *daemon) handleSocketConnection(conn net.Conn) {
 	defer conn.Close()
 
-	// Read execution report from exec mode
+	// Read framed messages from exec mode. A connection carries either a single
+	// execution report, or a streaming session for one run (execution_started and
+	// output chunks for each attempt, and keepalives). Sessions open with a
+	// keepalive, so they survive retry delays before any attempt has started.
 
 	// SECURITY: Set a read deadline to prevent indefinite blocking (Slowloris DoS).
 	// If a client connects but sends data too slowly (or not at all), we must timeout
@@ -546,19 +1162,110 @@ func (d *daemon) handleSocketConnection(conn net.Conn) {
 		return
 	}
 
-	// SECURITY: Limit the size of the request to prevent DoS (OOM) from a local attacker.
-	// 1MB is sufficient for legitimate reports (256KB stdout + 256KB stderr + metadata).
-	const maxReportSize = 1024 * 1024 // 1MB
-	limitReader := io.LimitReader(conn, maxReportSize)
+	// SECURITY: Limit the size of each message to prevent DoS (OOM) from a local attacker.
+	limitReader := &messageLimitReader{r: conn, remaining: maxReportSize}
 
 	decoder := json.NewDecoder(limitReader)
-	var report protocol.ExecutionReportPayload
-	if err := decoder.Decode(&report); err != nil {
-		log.Printf("Failed to decode execution report: %v", err)
-		return
+	var stream *execStream
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			// EOF is a normal close, e.g. a session whose job failed to start.
+			if err != io.EOF {
+				log.Printf("Failed to decode socket message: %v", err)
+			}
+			return
+		}
+
+		var msg socketMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			log.Printf("Failed to decode socket message: %v", err)
+			return
+		}
+
+		switch msg.Type {
+		case "", "execution_report":
+			// Older exec wrappers send a bare report without framing.
+			data := raw
+			if msg.Type != "" {
+				data = msg.Payload
+			}
+			var report protocol.ExecutionReportPayload
+			if err := json.Unmarshal(data, &report); err != nil {
+				log.Printf("Failed to decode execution report: %v", err)
+				return
+			}
+
+			log.Printf("Received execution report: job=%s, exitCode=%d", report.JobID, report.ExitCode)
+
+			d.deliverReport(report)
+			return
+
+		case "execution_started":
+			// Every attempt of a run with retries starts a new execution on the same session.
+			var started protocol.ExecutionStartedPayload
+			if json.Unmarshal(msg.Payload, &started) != nil || !validExecutionID(started.ExecutionID) {
+				log.Printf("Invalid execution_started message")
+				return
+			}
+			if stream == nil {
+				stream = newExecStream(conn)
+				defer d.unregisterStream(stream)
+			}
+			if !d.registerStream(stream, started.ExecutionID) {
+				log.Printf("Execution %s is already running", started.ExecutionID)
+				return
+			}
+			d.relayLive(protocol.ExecutionStartedMessage{Type: "execution_started", Payload: started})
+
+		case "execution_output":
+			var chunk protocol.ExecutionOutputPayload
+			if stream == nil || json.Unmarshal(msg.Payload, &chunk) != nil || chunk.ExecutionID != stream.executionID {
+				log.Printf("Invalid execution_output message")
+				return
+			}
+			d.relayOutput(stream, chunk)
+
+		case "keepalive":
+
+		default:
+			log.Printf("Unknown socket message type: %s", msg.Type)
+			return
+		}
+
+		// Streaming sessions may be quiet between messages for as long as the job
+		// produces no output; exec mode sends keepalives well within the limit.
+		if err := conn.SetReadDeadline(time.Now().Add(streamIdleTimeout)); err != nil {
+			return
+		}
+		limitReader.remaining = maxReportSize
 	}
+}
 
-	log.Printf("Received execution report: job=%s, exitCode=%d", report.JobID, report.ExitCode)
+// deliverReport stores a report in the outbox and forwards it to the server.
+// Without an outbox, delivery falls back to a single best-effort send. It
+// returns an error if the report was neither persisted nor sent.
+func (d *daemon) deliverReport(report protocol.ExecutionReportPayload) error {
+	// Exec mode assigns the execution ID; only replace it if it is missing or unsafe.
+	if !validExecutionID(report.ExecutionID) {
+		report.ExecutionID = newExecutionID()
+	}
+
+	// SECURITY: Redact before the report is persisted or sent, so raw output
+	// never leaves the host. Exec mode has already redacted injected secrets.
+	r := d.newReportRedactor()
+	report.Stdout = r.redact(report.Stdout)
+	report.Stderr = r.redact(report.Stderr)
+	report.Redactions += r.count
+
+	if d.outbox != nil {
+		err := d.outbox.put(report)
+		if err == nil {
+			d.flushOutbox()
+			return nil
+		}
+		log.Printf("Failed to persist execution report %s: %v", report.ExecutionID, err)
+	}
 
 	msg := protocol.ExecutionReportMessage{
 		Type:    "execution_report",
@@ -567,5 +1274,102 @@ func (d *daemon) handleSocketConnection(conn net.Conn) {
 
 	if err := d.sendMessage(msg); err != nil {
 		log.Printf("Failed to forward execution report: %v", err)
+		return err
+	}
+	return nil
+}
+
+// setServerRedactPatterns replaces the redaction patterns supplied by the server.
+func (d *daemon) setServerRedactPatterns(exprs []string) {
+	patterns := compileRedactPatterns(exprs)
+	d.redactMu.Lock()
+	d.serverRedactPatterns = patterns
+	d.redactMu.Unlock()
+}
+
+// newReportRedactor returns a redactor with the built-in, local and server
+// patterns and the secret store's values.
+func (d *daemon) newReportRedactor() *redactor {
+	d.redactMu.Lock()
+	defer d.redactMu.Unlock()
+
+	patterns := make([]*regexp.Regexp, 0, len(builtinRedactPatterns)+len(d.localRedactPatterns)+len(d.serverRedactPatterns))
+	patterns = append(patterns, builtinRedactPatterns...)
+	patterns = append(patterns, d.localRedactPatterns...)
+	patterns = append(patterns, d.serverRedactPatterns...)
+	return &redactor{values: d.secretValues, patterns: patterns}
+}
+
+// flushOutbox sends every outbox report not in flight on the current connection,
+// oldest first. Reports stay on disk until acknowledged via report_ack.
+func (d *daemon) flushOutbox() {
+	if d.outbox == nil {
+		return
+	}
+
+	d.outboxMu.Lock()
+	defer d.outboxMu.Unlock()
+
+	if !d.registered {
+		return
+	}
+
+	// Reports are read from disk only when they are sent.
+	for _, id := range d.outbox.ids() {
+		if _, sent := d.inflight[id]; sent {
+			continue
+		}
+		e, err := d.outbox.load(id)
+		if err != nil {
+			log.Printf("Failed to read execution report %s from the outbox: %v", id, err)
+			continue
+		}
+		msg := protocol.ExecutionReportMessage{
+			Type:    "execution_report",
+			Payload: e.report,
+		}
+		if err := d.sendMessage(msg); err != nil {
+			// Keep the remainder queued; the next connection replays it.
+			log.Printf("Failed to forward execution report %s: %v", e.id, err)
+			return
+		}
+		d.inflight[e.id] = time.Now()
+	}
+}
+
+// retransmitUnacked resends reports whose acknowledgement has timed out.
+func (d *daemon) retransmitUnacked() {
+	if d.outbox == nil {
+		return
 	}
+
+	d.outboxMu.Lock()
+	expired := 0
+	for id, sentAt := range d.inflight {
+		if time.Since(sentAt) > reportAckTimeout {
+			delete(d.inflight, id)
+			expired++
+		}
+	}
+	d.outboxMu.Unlock()
+
+	if expired > 0 {
+		log.Printf("Retransmitting %d unacknowledged execution reports", expired)
+		d.flushOutbox()
+	}
+}
+
+// ackReport removes an acknowledged report from the outbox.
+func (d *daemon) ackReport(executionID string) {
+	if d.outbox == nil {
+		return
+	}
+	if err := d.outbox.remove(executionID); err != nil {
+		log.Printf("Failed to remove acknowledged report %q: %v", executionID, err)
+		return
+	}
+
+	d.outboxMu.Lock()
+	delete(d.inflight, executionID)
+	d.outboxMu.Unlock()
 }
@@ -1,10 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/croncommander/cc-agent/internal/protocol"
+	"github.com/gorilla/websocket"
 )
 
 func TestGenerateCronContent_Sanitization(t *testing.T) {
@@ -93,3 +100,467 @@ func TestGenerateCronContent_CommandInjection(t *testing.T) {
 		t.Errorf("Vulnerability found: Job ID injection possible")
 	}
 }
+
+func TestGenerateCronContent_EnvKeptOutOfCronFile(t *testing.T) {
+	jobs := []protocol.JobDefinition{
+		{
+			JobID:          "env-job",
+			CronExpression: "0 * * * *",
+			Command:        "./migrate.sh",
+			Env:            map[string]string{"DATABASE_URL": "postgres://user:s3cret@db/app"},
+			WorkingDir:     "/srv/app",
+		},
+	}
+
+	output := string(generateCronContent(jobs, true))
+
+	if strings.Contains(output, "s3cret") || strings.Contains(output, "DATABASE_URL") {
+		t.Errorf("Environment leaked into cron file: %s", output)
+	}
+	if !strings.Contains(output, " --spec ") {
+		t.Errorf("Expected job to reference its spec file. Got: %s", output)
+	}
+}
+
+func TestMergeEnv(t *testing.T) {
+	base := []string{"PATH=/usr/bin:/bin", "LANG=C.UTF-8"}
+	merged := mergeEnv(base, map[string]string{"PATH": "/opt/bin", "TZ": "UTC"})
+
+	want := map[string]bool{"PATH=/opt/bin": true, "LANG=C.UTF-8": true, "TZ=UTC": true}
+	if len(merged) != len(want) {
+		t.Fatalf("mergeEnv() = %v, want %d entries", merged, len(want))
+	}
+	for _, kv := range merged {
+		if !want[kv] {
+			t.Errorf("Unexpected entry %q in %v", kv, merged)
+		}
+	}
+}
+
+func TestExecArgs_ExtraFlagsBeforeCommand(t *testing.T) {
+	job := protocol.JobDefinition{JobID: "job-1", Command: "echo hi", TimeoutSeconds: 30}
+	args := execArgs(job, "--trigger", triggerManual)
+
+	want := []string{"--timeout", "30", "--trigger", "manual", "--", "/bin/sh", "-c", "echo hi"}
+	got := args[len(args)-len(want):]
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("execArgs() tail = %q, want %q", got, want)
+	}
+	if args[0] != "exec" || args[1] != "--job-id" || args[2] != "job-1" {
+		t.Errorf("execArgs() head = %q", args[:3])
+	}
+}
+
+func TestValidateJobs_RejectsInvalidCronExpressions(t *testing.T) {
+	d := &daemon{executionMode: "user"}
+	jobs := []protocol.JobDefinition{
+		{JobID: "ok", CronExpression: "@daily", Command: "true"},
+		{JobID: "six-fields", CronExpression: "0 0 * * * *", Command: "true"},
+		{JobID: "every", CronExpression: "@every 5m", Command: "true"},
+		{JobID: "range", CronExpression: "99 * * * *", Command: "true"},
+	}
+
+	valid, rejected := d.validateJobs(jobs)
+	if len(valid) != 1 || valid[0].JobID != "ok" {
+		t.Fatalf("valid = %v, want only job ok", valid)
+	}
+	if valid[0].CronExpression != "0 0 * * *" {
+		t.Errorf("CronExpression = %q, want normalized macro", valid[0].CronExpression)
+	}
+	if len(rejected) != 3 {
+		t.Fatalf("rejected = %v, want 3 jobs", rejected)
+	}
+	for _, r := range rejected {
+		if r.Reason == "" {
+			t.Errorf("job %s rejected without a reason", r.JobID)
+		}
+	}
+}
+
+func TestGenerateCronContent_GroupsJobsByTimezone(t *testing.T) {
+	jobs := []protocol.JobDefinition{
+		{JobID: "berlin", CronExpression: "0 2 * * *", Command: "true", Timezone: "Europe/Berlin"},
+		{JobID: "local", CronExpression: "0 3 * * *", Command: "true"},
+		{JobID: "tokyo", CronExpression: "0 4 * * *", Command: "true", Timezone: "Asia/Tokyo"},
+		{JobID: "berlin-2", CronExpression: "0 5 * * *", Command: "true", Timezone: "Europe/Berlin"},
+	}
+
+	defer func(old string) { localtimePath = old }(localtimePath)
+	localtimePath = filepath.Join(t.TempDir(), "missing") // Host zone is UTC
+
+	output := string(generateCronContent(jobs, false))
+
+	// Host-zone jobs must precede every CRON_TZ line, which applies to all later
+	// entries. In user mode they follow an explicit reset to the host zone.
+	order := []string{"CRON_TZ=UTC\n", "--job-id 'local'", "CRON_TZ=Asia/Tokyo\n", "--job-id 'tokyo'", "CRON_TZ=Europe/Berlin\n", "--job-id 'berlin'", "--job-id 'berlin-2'"}
+	pos := 0
+	for _, s := range order {
+		i := strings.Index(output[pos:], s)
+		if i < 0 {
+			t.Fatalf("%q missing or out of order in:\n%s", s, output)
+		}
+		pos += i + len(s)
+	}
+	if strings.Count(output, "CRON_TZ=") != 3 {
+		t.Errorf("want a host zone reset and one CRON_TZ line per zone:\n%s", output)
+	}
+}
+
+func TestGenerateCronContent_HostZoneReset(t *testing.T) {
+	defer func(old string) { localtimePath = old }(localtimePath)
+	dir := t.TempDir()
+	localtimePath = filepath.Join(dir, "localtime")
+	if err := os.Symlink("/usr/share/zoneinfo/America/New_York", localtimePath); err != nil {
+		t.Fatal(err)
+	}
+	jobs := []protocol.JobDefinition{{JobID: "local", CronExpression: "0 3 * * *", Command: "true"}}
+
+	// A CRON_TZ line earlier in the user's crontab must not shift host-zone jobs.
+	user := string(generateCronContent(jobs, false))
+	reset := strings.Index(user, "CRON_TZ=America/New_York\n")
+	if reset < 0 || reset > strings.Index(user, "--job-id 'local'") {
+		t.Errorf("user mode content lacks a host zone reset before the job:\n%s", user)
+	}
+
+	// /etc/cron.d files do not share state with other files.
+	if system := string(generateCronContent(jobs, true)); strings.Contains(system, "CRON_TZ") {
+		t.Errorf("system mode content has a CRON_TZ line:\n%s", system)
+	}
+
+	// Without host-zone jobs there is nothing to reset.
+	zoned := []protocol.JobDefinition{{JobID: "tokyo", CronExpression: "0 4 * * *", Command: "true", Timezone: "Asia/Tokyo"}}
+	if got := string(generateCronContent(zoned, false)); strings.Count(got, "CRON_TZ=") != 1 {
+		t.Errorf("want only the job's own CRON_TZ line:\n%s", got)
+	}
+}
+
+func TestValidateJobs_Timezone(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Berlin"); err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	jobs := []protocol.JobDefinition{
+		{JobID: "berlin", CronExpression: "0 2 * * *", Command: "true", Timezone: "Europe/Berlin"},
+		{JobID: "unknown", CronExpression: "0 2 * * *", Command: "true", Timezone: "Mars/Olympus_Mons"},
+		{JobID: "local", CronExpression: "0 2 * * *", Command: "true", Timezone: "Local"},
+	}
+
+	tests := []struct {
+		name  string
+		d     *daemon
+		valid int
+	}{
+		{"cronie", &daemon{executionMode: "user", scheduler: schedulerCron, cronTZ: true}, 1},
+		{"cron without CRON_TZ", &daemon{executionMode: "user", scheduler: schedulerCron}, 0},
+		{"systemd", &daemon{executionMode: "user", scheduler: schedulerSystemd}, 1},
+		{"internal", &daemon{executionMode: "user", scheduler: schedulerInternal}, 1},
+	}
+	for _, tt := range tests {
+		valid, rejected := tt.d.validateJobs(jobs)
+		if len(valid) != tt.valid || len(rejected) != len(jobs)-tt.valid {
+			t.Errorf("%s: valid = %v, rejected = %v", tt.name, valid, rejected)
+		}
+	}
+}
+
+func TestValidateJobs_Jitter(t *testing.T) {
+	d := &daemon{executionMode: "user"}
+	jobs := []protocol.JobDefinition{
+		{JobID: "ok", CronExpression: "0 * * * *", Command: "true", JitterSeconds: 300},
+		{JobID: "negative", CronExpression: "0 * * * *", Command: "true", JitterSeconds: -1},
+		{JobID: "too-long", CronExpression: "0 * * * *", Command: "true", JitterSeconds: maxJitterSeconds + 1},
+	}
+
+	valid, rejected := d.validateJobs(jobs)
+	if len(valid) != 1 || len(rejected) != 2 {
+		t.Fatalf("valid = %v, rejected = %v", valid, rejected)
+	}
+	if args := strings.Join(execArgs(valid[0]), " "); !strings.Contains(args, " --jitter 300 ") {
+		t.Errorf("execArgs() = %q, want --jitter 300", args)
+	}
+}
+
+func TestValidateJobs_Retries(t *testing.T) {
+	d := &daemon{executionMode: "user"}
+	jobs := []protocol.JobDefinition{
+		{JobID: "ok", CronExpression: "0 * * * *", Command: "true", Retries: 3, RetryDelaySeconds: 5, RetryOnExitCodes: []int{75, 111}},
+		{JobID: "too-many", CronExpression: "0 * * * *", Command: "true", Retries: maxRetries + 1},
+		{JobID: "bad-code", CronExpression: "0 * * * *", Command: "true", Retries: 1, RetryOnExitCodes: []int{0}},
+	}
+
+	valid, rejected := d.validateJobs(jobs)
+	if len(valid) != 1 || len(rejected) != 2 {
+		t.Fatalf("valid = %v, rejected = %v", valid, rejected)
+	}
+	args := strings.Join(execArgs(valid[0]), " ")
+	if !strings.Contains(args, " --retries 3 --retry-delay 5 --retry-on-exit-codes 75,111 ") {
+		t.Errorf("execArgs() = %q, want retry flags", args)
+	}
+}
+
+// connectTestServer connects d to a local WebSocket server and returns the
+// messages the daemon sends to it.
+func connectTestServer(t *testing.T, d *daemon) <-chan []byte {
+	t.Helper()
+	received := make(chan []byte, 16)
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- data
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	d.conn = conn
+	return received
+}
+
+// nextMessage returns the next message sent to the test server, or fails.
+func nextMessage(t *testing.T, received <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestSyncCron_Ack(t *testing.T) {
+	defer func(old string) { stateDir = old }(stateDir)
+
+	tests := []struct {
+		name        string
+		crontab     string // Extra script lines for "crontab -"
+		jobs        []protocol.JobDefinition
+		wantOutcome string
+		wantSkipped []string
+		wantOutput  string
+	}{
+		{
+			name:        "applied",
+			jobs:        []protocol.JobDefinition{{JobID: "ok", CronExpression: "*/5 * * * *", Command: "true"}},
+			wantOutcome: "applied",
+		},
+		{
+			name: "rejected job",
+			jobs: []protocol.JobDefinition{
+				{JobID: "ok", CronExpression: "*/5 * * * *", Command: "true"},
+				{JobID: "bad", CronExpression: "99 * * * *", Command: "true"},
+			},
+			wantOutcome: "applied",
+			wantSkipped: []string{"bad"},
+		},
+		{
+			name:        "install failure",
+			crontab:     `[ "$1" = - ] && { echo "crontab: bad minute" >&2; exit 1; }`,
+			jobs:        []protocol.JobDefinition{{JobID: "ok", CronExpression: "*/5 * * * *", Command: "true"}},
+			wantOutcome: "failed",
+			wantOutput:  "crontab: bad minute",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stateDir = t.TempDir()
+			fakeCrontab(t)
+			if tt.crontab != "" {
+				dir := t.TempDir()
+				script := "#!/bin/sh\n" + tt.crontab + "\nexit 0\n"
+				if err := os.WriteFile(filepath.Join(dir, "crontab"), []byte(script), 0755); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+			}
+			d := &daemon{executionMode: "user"}
+			received := connectTestServer(t, d)
+
+			d.syncCron(tt.jobs)
+
+			var result protocol.SyncResultMessage
+			if err := json.Unmarshal(nextMessage(t, received), &result); err != nil || result.Type != "sync_result" {
+				t.Fatalf("first message = %+v (%v), want sync_result", result, err)
+			}
+			var ack protocol.SyncAckMessage
+			if err := json.Unmarshal(nextMessage(t, received), &ack); err != nil || ack.Type != "sync_ack" {
+				t.Fatalf("second message = %+v (%v), want sync_ack", ack, err)
+			}
+
+			if ack.Outcome != tt.wantOutcome {
+				t.Errorf("Outcome = %q, want %q (error %q)", ack.Outcome, tt.wantOutcome, ack.Error)
+			}
+			var skipped []string
+			for _, s := range ack.Skipped {
+				skipped = append(skipped, s.JobID)
+			}
+			if strings.Join(skipped, ",") != strings.Join(tt.wantSkipped, ",") {
+				t.Errorf("Skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+			if len(result.Rejected) != len(ack.Skipped) {
+				t.Errorf("sync_result rejected %v, sync_ack skipped %v", result.Rejected, ack.Skipped)
+			}
+			if tt.wantOutcome == "applied" {
+				if ack.Installed != len(tt.jobs)-len(tt.wantSkipped) || ack.ContentHash == "" || ack.Error != "" {
+					t.Errorf("ack = %+v, want installed jobs and a content hash", ack)
+				}
+			} else if ack.Error == "" || ack.ContentHash != "" || !strings.Contains(ack.CrontabOutput, tt.wantOutput) {
+				t.Errorf("ack = %+v, want an error and crontab output %q", ack, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestGenerateCronContent_QuotingFormat(t *testing.T) {
+	jobs := []protocol.JobDefinition{{
+		JobID:             "j-1",
+		CronExpression:    "*/5 * * * *",
+		Command:           "echo 'hi'",
+		TimeoutSeconds:    30,
+		RunAs:             "backup",
+		ConcurrencyPolicy: concurrencyForbid,
+		Retries:           2,
+	}}
+	content := string(generateCronContent(jobs, true))
+
+	// Values are single-quoted; flag names, numbers and fixed words are not.
+	want := " exec --job-id 'j-1' --socket-path '" + socketPath + "' --state-dir '" + stateDir +
+		"' --timeout 30 --run-as 'backup' --concurrency-policy forbid --retries 2 -- /bin/sh -c 'echo '\\''hi'\\'''\n"
+	if !strings.Contains(content, want) {
+		t.Errorf("cron entry not in the expected format:\n%s\nwant suffix:\n%s", content, want)
+	}
+}
+
+// newOutboxDaemon returns a registered daemon with an outbox, connected to a
+// test server.
+func newOutboxDaemon(t *testing.T) (*daemon, <-chan []byte) {
+	t.Helper()
+	ob, err := newOutbox(filepath.Join(t.TempDir(), "outbox"), 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+	d := &daemon{outbox: ob, registered: true, inflight: make(map[string]time.Time)}
+	return d, connectTestServer(t, d)
+}
+
+// nextReport returns the execution ID of the next report sent to the test server.
+func nextReport(t *testing.T, received <-chan []byte) string {
+	t.Helper()
+	var msg protocol.ExecutionReportMessage
+	if err := json.Unmarshal(nextMessage(t, received), &msg); err != nil || msg.Type != "execution_report" {
+		t.Fatalf("message = %+v (%v), want execution_report", msg, err)
+	}
+	return msg.Payload.ExecutionID
+}
+
+func TestReportAck_RemovesFromOutbox(t *testing.T) {
+	d, received := newOutboxDaemon(t)
+
+	if err := d.deliverReport(protocol.ExecutionReportPayload{ExecutionID: "exec-1", JobID: "j"}); err != nil {
+		t.Fatalf("deliverReport failed: %v", err)
+	}
+	if id := nextReport(t, received); id != "exec-1" {
+		t.Fatalf("sent report %q, want exec-1", id)
+	}
+	if entries, _ := d.outbox.pending(); len(entries) != 1 {
+		t.Fatalf("outbox has %d reports before the ack, want 1", len(entries))
+	}
+
+	d.handleMessage([]byte(`{"type":"report_ack","executionId":"exec-1"}`))
+
+	if entries, _ := d.outbox.pending(); len(entries) != 0 {
+		t.Errorf("acknowledged report still in the outbox: %+v", entries)
+	}
+	if len(d.inflight) != 0 {
+		t.Errorf("acknowledged report still in flight: %v", d.inflight)
+	}
+}
+
+func TestRetransmitUnacked_ResendsAfterTimeout(t *testing.T) {
+	d, received := newOutboxDaemon(t)
+
+	d.deliverReport(protocol.ExecutionReportPayload{ExecutionID: "exec-1", JobID: "j"})
+	nextReport(t, received)
+
+	// Within the timeout nothing is resent.
+	d.retransmitUnacked()
+	select {
+	case data := <-received:
+		t.Fatalf("report resent before the ack timeout: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	d.outboxMu.Lock()
+	d.inflight["exec-1"] = time.Now().Add(-reportAckTimeout - time.Second)
+	d.outboxMu.Unlock()
+	d.retransmitUnacked()
+
+	// The resent report keeps its execution ID so the server can dedupe it.
+	if id := nextReport(t, received); id != "exec-1" {
+		t.Errorf("resent report has execution ID %q, want exec-1", id)
+	}
+	if entries, _ := d.outbox.pending(); len(entries) != 1 {
+		t.Errorf("outbox has %d reports after the resend, want 1", len(entries))
+	}
+}
+
+func TestFlushOutbox_ReplaysAfterReconnect(t *testing.T) {
+	d, received := newOutboxDaemon(t)
+
+	d.deliverReport(protocol.ExecutionReportPayload{ExecutionID: "exec-1", JobID: "j"})
+	nextReport(t, received)
+
+	// A new connection starts unregistered and with nothing in flight.
+	received = connectTestServer(t, d)
+	d.outboxMu.Lock()
+	d.registered = false
+	d.inflight = make(map[string]time.Time)
+	d.outboxMu.Unlock()
+
+	d.deliverReport(protocol.ExecutionReportPayload{ExecutionID: "exec-2", JobID: "j"})
+	select {
+	case data := <-received:
+		t.Fatalf("report sent before registration: %s", data)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	d.handleMessage([]byte(`{"type":"register_ack","status":"success","agentId":"a"}`))
+	for _, want := range []string{"exec-1", "exec-2"} {
+		if id := nextReport(t, received); id != want {
+			t.Errorf("replayed report %q, want %q", id, want)
+		}
+	}
+}
+
+func TestValidateJobs_Reboot(t *testing.T) {
+	jobs := []protocol.JobDefinition{{JobID: "boot", CronExpression: "@Reboot", Command: "true"}}
+
+	d := &daemon{executionMode: "user", scheduler: schedulerCron}
+	valid, rejected := d.validateJobs(jobs)
+	if len(valid) != 1 || len(rejected) != 0 || valid[0].CronExpression != "@reboot" {
+		t.Fatalf("cron: valid = %v, rejected = %v", valid, rejected)
+	}
+	if content := string(generateCronContent(valid, false)); !strings.Contains(content, "\n@reboot ") {
+		t.Errorf("@reboot not passed to cron unchanged:\n%s", content)
+	}
+
+	for _, scheduler := range []string{schedulerSystemd, schedulerInternal} {
+		d := &daemon{executionMode: "user", scheduler: scheduler}
+		valid, rejected := d.validateJobs(jobs)
+		if len(valid) != 0 || len(rejected) != 1 || !strings.Contains(rejected[0].Reason, scheduler) {
+			t.Errorf("%s: valid = %v, rejected = %v, want rejected naming the scheduler", scheduler, valid, rejected)
+		}
+	}
+}
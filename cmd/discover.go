@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/croncommander/cc-agent/internal/cronexpr"
+	"github.com/croncommander/cc-agent/internal/protocol"
+	"github.com/spf13/cobra"
+)
+
+const (
+	systemCrontabPath = "/etc/crontab"
+	cronDropInDir     = "/etc/cron.d"
+)
+
+var (
+	// cronEnvLine matches environment assignments such as MAILTO=ops.
+	cronEnvLine = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\s*=`)
+	// cronDropInName matches the /etc/cron.d file names cron reads; others,
+	// such as editor backups and package manager leftovers, are ignored by cron.
+	cronDropInName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+var importJSON bool
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "List existing crontab entries that can be adopted as managed jobs",
+	Long: `Scan the current user's crontab, /etc/crontab and /etc/cron.d/* for cron
+entries not managed by CronCommander and print them.
+
+The daemon reports the same entries to the server after registering, so they
+can be adopted as managed jobs from the dashboard.`,
+	Run: runImport,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().BoolVar(&importJSON, "json", false, "Print entries as JSON")
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	jobs := discoverJobs()
+
+	if importJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(jobs); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SOURCE\tUSER\tSCHEDULE\tCOMMAND")
+	for _, job := range jobs {
+		schedule := job.CronExpression
+		if job.Error != "" {
+			schedule = "invalid: " + job.Error
+		}
+		fmt.Fprintf(w, "%s:%d\t%s\t%s\t%s\n", job.Source, job.Line, job.User, schedule, job.Command)
+	}
+	w.Flush()
+}
+
+// discoverJobs returns the unmanaged cron entries on this host. Sources that
+// cannot be read are skipped.
+func discoverJobs() []protocol.DiscoveredJob {
+	jobs := []protocol.DiscoveredJob{}
+
+	currentUser := "unknown"
+	if u, err := user.Current(); err == nil {
+		currentUser = u.Username
+	}
+	if crontab, err := readUserCrontab(); err != nil {
+		log.Printf("Skipping user crontab: %v", err)
+	} else if rest, _, err := splitManagedBlock(crontab); err != nil {
+		log.Printf("Skipping user crontab: %v", err)
+	} else {
+		jobs = append(jobs, parseCrontab(rest, "crontab:"+currentUser, currentUser)...)
+	}
+
+	if data, err := os.ReadFile(systemCrontabPath); err == nil {
+		jobs = append(jobs, parseCrontab(data, systemCrontabPath, "")...)
+	}
+
+	entries, _ := os.ReadDir(cronDropInDir)
+	for _, e := range entries {
+		path := filepath.Join(cronDropInDir, e.Name())
+		if path == cronFilePath || !e.Type().IsRegular() || !cronDropInName.MatchString(e.Name()) {
+			continue
+		}
+		if data, err := os.ReadFile(path); err == nil {
+			jobs = append(jobs, parseCrontab(data, path, "")...)
+		}
+	}
+	return jobs
+}
+
+// parseCrontab extracts entries from crontab content. If owner is empty the
+// content is in system format, with a user field after the schedule.
+// Entries that already run through cc-agent exec are skipped.
+func parseCrontab(content []byte, source, owner string) []protocol.DiscoveredJob {
+	var jobs []protocol.DiscoveredJob
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || cronEnvLine.MatchString(line) {
+			continue
+		}
+
+		scheduleFields := 5
+		if strings.HasPrefix(line, "@") {
+			scheduleFields = 1
+		}
+		userFields := 0
+		if owner == "" {
+			userFields = 1
+		}
+		fields, command := splitCronFields(line, scheduleFields+userFields)
+		if command == "" {
+			continue
+		}
+		if isAgentCommand(command) {
+			continue
+		}
+
+		job := protocol.DiscoveredJob{
+			Source:  source,
+			Line:    lineNo,
+			User:    owner,
+			Command: command,
+		}
+		if owner == "" {
+			job.User = fields[scheduleFields]
+		}
+		expr := strings.Join(fields[:scheduleFields], " ")
+		if schedule, err := cronexpr.Parse(expr); err != nil {
+			job.CronExpression = expr
+			job.Error = err.Error()
+		} else {
+			job.CronExpression = schedule.String()
+		}
+
+		job.Fingerprint = discoveredFingerprint(job)
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// discoveredFingerprint identifies an entry across scans.
+func discoveredFingerprint(job protocol.DiscoveredJob) string {
+	sum := sha256.Sum256([]byte(job.Source + "\x00" + job.User + "\x00" + job.CronExpression + "\x00" + job.Command))
+	return hex.EncodeToString(sum[:8])
+}
+
+// splitCronFields splits the first n whitespace-separated fields off line and
+// returns them with the remainder, which keeps its original spacing.
+func splitCronFields(line string, n int) ([]string, string) {
+	fields := make([]string, 0, n)
+	rest := line
+	for len(fields) < n {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			return fields, ""
+		}
+		end := strings.IndexAny(rest, " \t")
+		if end < 0 {
+			return append(fields, rest), ""
+		}
+		fields = append(fields, rest[:end])
+		rest = rest[end:]
+	}
+	return fields, strings.TrimSpace(rest)
+}
+
+// isAgentCommand reports whether a cron command already runs through exec mode.
+func isAgentCommand(command string) bool {
+	return strings.HasPrefix(command, agentExecutable()+" exec ") ||
+		strings.Contains(command, "cc-agent exec ")
+}
+
+// sendDiscoveredJobs reports unmanaged cron entries to the server. Nothing is
+// sent if the entries are unchanged since the last report, e.g. on reconnect.
+func (d *daemon) sendDiscoveredJobs() {
+	jobs := d.redactDiscoveredJobs(discoverJobs())
+
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		log.Printf("Failed to encode discovered_jobs: %v", err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+
+	d.discoverMu.Lock()
+	defer d.discoverMu.Unlock()
+	if digest == d.discoveredSum {
+		return
+	}
+
+	log.Printf("Reporting %d unmanaged cron entries", len(jobs))
+	msg := protocol.DiscoveredJobsMessage{Type: "discovered_jobs", Jobs: jobs}
+	if err := d.sendMessage(msg); err != nil {
+		log.Printf("Failed to send discovered_jobs: %v", err)
+		return
+	}
+	d.discoveredSum = digest
+}
+
+// redactDiscoveredJobs masks secrets in discovered commands, since crontabs
+// often carry credentials inline. Fingerprints are recomputed from the redacted
+// entries so they cannot be used to guess the originals.
+func (d *daemon) redactDiscoveredJobs(jobs []protocol.DiscoveredJob) []protocol.DiscoveredJob {
+	r := d.newReportRedactor()
+	for i := range jobs {
+		jobs[i].Command = r.redact(jobs[i].Command)
+		jobs[i].Fingerprint = discoveredFingerprint(jobs[i])
+	}
+	return jobs
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCrontab_SystemFormat(t *testing.T) {
+	content := `# m h dom mon dow user command
+SHELL=/bin/sh
+MAILTO = ops@example.com
+
+17 *	* * *	root    cd / && run-parts --report /etc/cron.hourly
+@daily backup /usr/local/bin/backup.sh  --full
+99 * * * * root /bin/broken
+* * * * * root /usr/local/bin/cc-agent exec --job-id x -- /bin/sh -c 'true'
+`
+	jobs := parseCrontab([]byte(content), "/etc/crontab", "")
+	if len(jobs) != 3 {
+		t.Fatalf("got %d jobs, want 3: %+v", len(jobs), jobs)
+	}
+
+	if jobs[0].User != "root" || jobs[0].CronExpression != "17 * * * *" || jobs[0].Command != "cd / && run-parts --report /etc/cron.hourly" || jobs[0].Line != 5 {
+		t.Errorf("unexpected first job: %+v", jobs[0])
+	}
+	if jobs[1].User != "backup" || jobs[1].CronExpression != "0 0 * * *" || jobs[1].Command != "/usr/local/bin/backup.sh  --full" {
+		t.Errorf("unexpected macro job: %+v", jobs[1])
+	}
+	if jobs[2].Error == "" {
+		t.Errorf("expected invalid schedule to be flagged: %+v", jobs[2])
+	}
+	if jobs[0].Fingerprint == "" || jobs[0].Fingerprint == jobs[1].Fingerprint {
+		t.Errorf("fingerprints are not distinct: %q, %q", jobs[0].Fingerprint, jobs[1].Fingerprint)
+	}
+}
+
+func TestParseCrontab_UserFormat(t *testing.T) {
+	jobs := parseCrontab([]byte("*/10 * * * * /home/me/poll.sh\n"), "crontab:me", "me")
+	if len(jobs) != 1 || jobs[0].User != "me" || jobs[0].Command != "/home/me/poll.sh" || jobs[0].CronExpression != "*/10 * * * *" {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestRedactDiscoveredJobs(t *testing.T) {
+	d := &daemon{localRedactPatterns: compileRedactPatterns([]string{`password=\S+`})}
+	jobs := parseCrontab([]byte("@hourly mysqldump --password=hunter2 db\n"), "crontab:me", "me")
+	original := jobs[0].Fingerprint
+
+	jobs = d.redactDiscoveredJobs(jobs)
+	if strings.Contains(jobs[0].Command, "hunter2") {
+		t.Errorf("command not redacted: %q", jobs[0].Command)
+	}
+	if jobs[0].Fingerprint == original {
+		t.Errorf("fingerprint still derived from the unredacted command")
+	}
+}
+
+func TestSendDiscoveredJobs_OnlyWhenChanged(t *testing.T) {
+	table := fakeCrontab(t)
+	os.WriteFile(table, []byte("@hourly /home/me/poll.sh\n"), 0644)
+
+	d := &daemon{executionMode: "user"}
+	received := connectTestServer(t, d)
+
+	d.sendDiscoveredJobs()
+	first := nextMessage(t, received)
+	if !strings.Contains(string(first), "/home/me/poll.sh") {
+		t.Fatalf("unexpected discovered_jobs message: %s", first)
+	}
+
+	// Unchanged entries, e.g. after a reconnect, are not sent again.
+	d.sendDiscoveredJobs()
+	os.WriteFile(table, []byte("@hourly /home/me/poll.sh\n@daily /home/me/report.sh\n"), 0644)
+	d.sendDiscoveredJobs()
+	second := nextMessage(t, received)
+	if !strings.Contains(string(second), "/home/me/report.sh") {
+		t.Errorf("expected the changed entries next, got %s", second)
+	}
+}
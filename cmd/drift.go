@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// driftCheckInterval is how often the installed cron table is compared with
+// the content the daemon last wrote.
+const driftCheckInterval = time.Minute
+
+// Drift actions configured via drift_action.
+const (
+	driftActionReport = "report"
+	driftActionRepair = "repair"
+)
+
+// contentHash returns the hex SHA-256 of cron content.
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// watchDrift periodically checks the installed cron table for drift.
+func (d *daemon) watchDrift() {
+	ticker := time.NewTicker(driftCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.checkDrift()
+	}
+}
+
+// checkDrift compares the installed cron table with the last content the
+// daemon wrote. Drift is reported once per distinct drifted content and, with
+// drift_action "repair", the desired content is written back.
+func (d *daemon) checkDrift() {
+	d.cronMu.Lock()
+	defer d.cronMu.Unlock()
+
+	if d.cronContent == nil {
+		// Nothing installed yet in this run.
+		return
+	}
+
+	actual, err := d.readInstalledCron()
+	if err != nil {
+		log.Printf("Failed to read %s for drift check: %v", d.cronTarget(), err)
+		return
+	}
+	if bytes.Equal(actual, d.cronContent) {
+		d.driftHash = ""
+		return
+	}
+
+	actualHash := contentHash(actual)
+	if actualHash == d.driftHash {
+		return
+	}
+
+	msg := protocol.DriftDetectedMessage{
+		Type:         "drift_detected",
+		Target:       d.cronTarget(),
+		ExpectedHash: contentHash(d.cronContent),
+		ActualHash:   actualHash,
+		Action:       d.driftAction,
+	}
+	log.Printf("Drift detected in %s", msg.Target)
+
+	reported := actualHash
+	if d.driftAction == driftActionRepair {
+		if _, err := d.writeCron(d.cronContent); err != nil {
+			msg.Error = err.Error()
+		} else {
+			log.Printf("Restored %s to the desired state", msg.Target)
+			msg.Repaired = true
+			reported = ""
+		}
+	}
+
+	if err := d.sendMessage(msg); err != nil {
+		// Not remembered, so the next check reports it again, e.g. after a reconnect.
+		log.Printf("Failed to send drift_detected: %v", err)
+		return
+	}
+	d.driftHash = reported
+}
+
+// readInstalledCron returns the cron content currently installed for this
+// execution mode: the cron file, or the managed block of the user crontab.
+// A missing cron file or crontab reads as empty.
+func (d *daemon) readInstalledCron() ([]byte, error) {
+	if d.executionMode == "system" {
+		data, err := os.ReadFile(cronFilePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return []byte{}, nil
+		}
+		return data, err
+	}
+
+	crontab, err := readUserCrontab()
+	if err != nil {
+		return nil, err
+	}
+	_, block, err := splitManagedBlock(crontab)
+	return block, err
+}
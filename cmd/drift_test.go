@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// fakeCrontab installs a crontab stub on PATH that stores the table in a file.
+func fakeCrontab(t *testing.T) string {
+	dir := t.TempDir()
+	table := filepath.Join(dir, "table")
+	script := `#!/bin/sh
+case "$1" in
+-l) [ -f "` + table + `" ] || { echo "no crontab for test" >&2; exit 1; }; cat "` + table + `" ;;
+-) cat > "` + table + `" ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "crontab"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return table
+}
+
+func TestCheckDrift_RepairsUserCrontab(t *testing.T) {
+	table := fakeCrontab(t)
+	d := &daemon{executionMode: "user", driftAction: driftActionRepair}
+
+	want := []byte("0 * * * * /bin/true\n")
+	if _, err := d.writeCron(want); err != nil {
+		t.Fatalf("writeCron failed: %v", err)
+	}
+	d.cronContent = want
+
+	// A hand edit inside the managed block is drift; entries outside it are not ours.
+	edited, _ := mergeManagedBlock([]byte("@daily /home/me/backup.sh\n"), []byte("* * * * * /bin/evil\n"))
+	os.WriteFile(table, edited, 0644)
+	d.checkDrift()
+
+	got, _ := os.ReadFile(table)
+	_, block, err := splitManagedBlock(got)
+	if err != nil || string(block) != string(want) {
+		t.Errorf("managed block after repair = %q (%v), want %q", block, err, want)
+	}
+	if !strings.Contains(string(got), "@daily /home/me/backup.sh") {
+		t.Errorf("repair removed an unmanaged entry: %q", got)
+	}
+}
+
+func TestCheckDrift_ReportOnlyLeavesContent(t *testing.T) {
+	table := fakeCrontab(t)
+	d := &daemon{executionMode: "user", driftAction: driftActionReport}
+	connectTestServer(t, d)
+	d.cronContent = []byte("0 * * * * /bin/true\n")
+
+	edited, _ := mergeManagedBlock(nil, []byte("edited\n"))
+	os.WriteFile(table, edited, 0644)
+	d.checkDrift()
+
+	got, _ := os.ReadFile(table)
+	if string(got) != string(edited) {
+		t.Errorf("report-only drift check modified the crontab: %q", got)
+	}
+	if d.driftHash != contentHash([]byte("edited\n")) {
+		t.Errorf("expected drift to be remembered so it is reported once")
+	}
+}
+
+func TestCheckDrift_ReportedAfterFailedSend(t *testing.T) {
+	table := fakeCrontab(t)
+	d := &daemon{executionMode: "user", driftAction: driftActionReport}
+	d.cronContent = []byte("0 * * * * /bin/true\n")
+
+	edited, _ := mergeManagedBlock(nil, []byte("edited\n"))
+	os.WriteFile(table, edited, 0644)
+
+	// Not connected: the drift must not be remembered as reported.
+	d.checkDrift()
+	if d.driftHash != "" {
+		t.Fatalf("drift remembered although drift_detected was not sent")
+	}
+
+	received := connectTestServer(t, d)
+	d.checkDrift()
+	var msg protocol.DriftDetectedMessage
+	if err := json.Unmarshal(nextMessage(t, received), &msg); err != nil || msg.Type != "drift_detected" {
+		t.Fatalf("message = %+v (%v), want drift_detected", msg, err)
+	}
+	if msg.ActualHash != contentHash([]byte("edited\n")) || d.driftHash != msg.ActualHash {
+		t.Errorf("drift_detected = %+v, driftHash = %q", msg, d.driftHash)
+	}
+}
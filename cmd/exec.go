@@ -3,21 +3,65 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/croncommander/cc-agent/internal/protocol"
 	"github.com/spf13/cobra"
 )
 
+// Report statuses for runs that did not execute normally.
+const (
+	statusSkipped   = "skipped"   // Not started because of the concurrency policy
+	statusCancelled = "cancelled" // Stopped by a cancel_execution request
+)
+
+// Bounds for job settings that are passed to exec mode.
+const (
+	maxJitterSeconds     = 3600
+	maxRetries           = 10
+	maxRetryDelaySeconds = 3600
+)
+
+// maxRetryBackoff caps the exponential delay between retries.
+const maxRetryBackoff = time.Hour
+
+// minimalPath is the PATH jobs run with.
+const minimalPath = "/usr/bin:/bin"
+
+// Execution triggers reported to the server.
+const (
+	triggerScheduled = "scheduled" // Started by cron
+	triggerManual    = "manual"    // Started by a run_job request
+	triggerAdhoc     = "adhoc"     // One-off command from an exec_adhoc request
+)
+
 var (
 	execJobID      string
 	execSocketPath string
+	execStateDir   string
+	execTimeout    int
+	execPolicy     string
+	execRunAs      string
+	execRunAsGroup string
+	execSpecPath   string
+	execTrigger    string
+	execID         string
+	execJitter     int
+	execStartDelay int
+	execRetries    int
+	execRetryDelay int
+	execRetryOn    []int
+	execLimits     string
 )
 
 var execCmd = &cobra.Command{
@@ -38,6 +82,23 @@ func init() {
 	rootCmd.AddCommand(execCmd)
 	execCmd.Flags().StringVarP(&execJobID, "job-id", "j", "", "Job ID for this execution")
 	execCmd.Flags().StringVar(&execSocketPath, "socket-path", "", "Path to daemon socket")
+	execCmd.Flags().StringVar(&execStateDir, "state-dir", "", "Path to agent state directory (report spool)")
+	execCmd.Flags().StringVar(&secretsKeyPath, "secrets-key", "", "Path to the secret store's key (default: in the state directory)")
+	execCmd.Flags().IntVar(&execTimeout, "timeout", 0, "Kill the command after this many seconds (0 = no timeout)")
+	execCmd.Flags().StringVar(&execPolicy, "concurrency-policy", concurrencyAllow, "Overlapping run policy: allow, forbid or replace")
+	execCmd.Flags().StringVar(&execRunAs, "run-as", "", "Run the command as this user (requires root)")
+	execCmd.Flags().StringVar(&execRunAsGroup, "run-as-group", "", "Run the command with this primary group (requires --run-as)")
+	execCmd.Flags().StringVar(&execSpecPath, "spec", "", "Path to the job spec file (environment, working directory)")
+	execCmd.Flags().StringVar(&execTrigger, "trigger", triggerScheduled, "What started this run: scheduled, manual or adhoc")
+	execCmd.Flags().StringVar(&execID, "execution-id", "", "Execution ID to report (generated if empty)")
+	execCmd.Flags().IntVar(&execJitter, "jitter", 0, "Delay scheduled runs by up to this many seconds, derived from hostname and job ID")
+	// The internal scheduler applies the jitter itself and passes the delay on for the report.
+	execCmd.Flags().IntVar(&execStartDelay, "start-delay-ms", 0, "Jitter delay already applied before this run, in milliseconds")
+	execCmd.Flags().MarkHidden("start-delay-ms")
+	execCmd.Flags().IntVar(&execRetries, "retries", 0, "Re-run a failed command up to this many times")
+	execCmd.Flags().IntVar(&execRetryDelay, "retry-delay", 0, "Seconds before the first retry; doubles after each further attempt")
+	execCmd.Flags().IntSliceVar(&execRetryOn, "retry-on-exit-codes", nil, "Only retry these exit codes (default: any non-zero)")
+	execCmd.Flags().StringVar(&execLimits, "limits", "", "Resource limits for the command, e.g. nofile=1024,cpu=60 (sizes in MB)")
 }
 
 func runExec(cmd *cobra.Command, args []string) {
@@ -48,6 +109,16 @@ func runExec(cmd *cobra.Command, args []string) {
 		os.Exit(1)
 	}
 
+	// Every execution gets a unique ID up front so the report can be retransmitted
+	// and deduplicated end to end. Manual runs use the ID the daemon acknowledged.
+	executionID := execID
+	if !validExecutionID(executionID) {
+		executionID = newExecutionID()
+	}
+	if execTrigger != triggerScheduled && execTrigger != triggerManual && execTrigger != triggerAdhoc {
+		execTrigger = triggerScheduled
+	}
+
 	// SECURITY: Collect execution context for audit logging
 	executingUID := os.Geteuid()
 	executingUser := "unknown"
@@ -58,6 +129,19 @@ func runExec(cmd *cobra.Command, args []string) {
 		executingUser = currentUser.Username
 	}
 
+	// Resolve the job's target user. The wrapper itself keeps its identity so it
+	// can still reach the daemon; only the command runs with dropped privileges.
+	var credential *syscall.Credential
+	var targetUser *user.User
+	if execRunAs != "" {
+		credential, targetUser, err = lookupCredential(execRunAs, execRunAsGroup)
+		if err != nil {
+			abortExec(executionID, commandArgs, executingUID, executingUser, err)
+		}
+		executingUID = int(credential.Uid)
+		executingUser = targetUser.Username
+	}
+
 	// SECURITY: Warn if running as root, but allow it for System Mode.
 	// In System Mode, jobs may legitimately run as root.
 	if executingUID == 0 {
@@ -68,6 +152,10 @@ func runExec(cmd *cobra.Command, args []string) {
 	// SECURITY: Warn if not running as an expected user.
 	// Configurable pool allows flexibility for different deployment environments.
 	allowedUsers := []string{"cc-agent-user", "root"}
+	if targetUser != nil {
+		// The target was approved by the daemon's allowlist before the job was installed.
+		allowedUsers = append(allowedUsers, targetUser.Username)
+	}
 	isAllowedUser := false
 	for _, u := range allowedUsers {
 		if executingUser == u {
@@ -93,7 +181,7 @@ func runExec(cmd *cobra.Command, args []string) {
 	// Do not inherit arbitrary environment variables from parent process.
 	// This limits what an attacker can exploit via environment manipulation.
 	minimalEnv := []string{
-		"PATH=/usr/bin:/bin",
+		"PATH=" + minimalPath,
 		"HOME=/var/lib/croncommander",
 		"LANG=C.UTF-8",
 		"LC_ALL=C.UTF-8",
@@ -103,66 +191,471 @@ func runExec(cmd *cobra.Command, args []string) {
 	// Jobs execute in a known location with restrictive permissions.
 	workDir := "/var/lib/croncommander"
 
+	if targetUser != nil {
+		// The agent's directory is not accessible to other users; use the target's home.
+		minimalEnv[1] = "HOME=" + targetUser.HomeDir
+		minimalEnv = append(minimalEnv, "USER="+targetUser.Username, "LOGNAME="+targetUser.Username)
+		workDir = "/"
+		if info, err := os.Stat(targetUser.HomeDir); err == nil && info.IsDir() {
+			workDir = targetUser.HomeDir
+		}
+	}
+
+	// Apply the job's own environment and working directory on top of the baseline.
+	// A job that expects settings must not run without them.
+	var spec *jobSpec
+	if execSpecPath != "" {
+		spec, err = loadJobSpec(execSpecPath, execJobID)
+		if err != nil {
+			abortExec(executionID, commandArgs, executingUID, executingUser, fmt.Errorf("failed to load job spec: %w", err))
+		}
+		minimalEnv = mergeEnv(minimalEnv, spec.Env)
+		if spec.WorkingDir != "" {
+			workDir = spec.WorkingDir
+		}
+	}
+
+	// A job that expects resource limits must not run without them.
+	limits, err := parseLimits(execLimits)
+	if err != nil {
+		abortExec(executionID, commandArgs, executingUID, executingUser, fmt.Errorf("invalid resource limits: %w", err))
+	}
+
+	// Spread scheduled runs of the same job across hosts. The delay is stable per
+	// host so each host keeps a regular interval. Manual runs start immediately.
+	delay := time.Duration(execStartDelay) * time.Millisecond
+	if execJitter > 0 && execTrigger == triggerScheduled {
+		delay = startDelay(getHostname(), execJobID, execJitter)
+		time.Sleep(delay)
+	}
+
+	// SIGTERM, e.g. from a run replacing this one, stops the job and any
+	// pending retries instead of killing the wrapper and orphaning the job.
+	terminate := make(chan os.Signal, 1)
+	signal.Notify(terminate, syscall.SIGTERM)
+
+	// Enforce the job's concurrency policy before starting anything.
+	var lock *jobLock
+	if execPolicy != "" && execPolicy != concurrencyAllow {
+		if !validConcurrencyPolicy(execPolicy) {
+			fmt.Fprintf(os.Stderr, "Error: invalid concurrency policy %q\n", execPolicy)
+			os.Exit(1)
+		}
+		lock, err = acquireJobLock(execBaseDir(), execJobID, execPolicy)
+		if err != nil {
+			// Skipped runs are still reported so the server can show them.
+			log.Printf("Skipping job %s: %v", execJobID, err)
+			report := protocol.ExecutionReportPayload{
+				ExecutionID:   executionID,
+				JobID:         execJobID,
+				Command:       strings.Join(commandArgs, " "),
+				ExecutingUID:  executingUID,
+				ExecutingUser: executingUser,
+				Warning:       securityWarning,
+				Stderr:        fmt.Sprintf("Run skipped (concurrency policy %q): %v", execPolicy, err),
+				StartTime:     time.Now().Format(time.RFC3339),
+				Status:        statusSkipped,
+				Trigger:       execTrigger,
+				StartDelayMs:  int(delay.Milliseconds()),
+			}
+			reportToDaemon(report)
+			os.Exit(0)
+		}
+	}
+
+	// Inject secrets from the local store. Their values are redacted from the
+	// captured output before the report leaves this process.
+	var redact redactor
+	var secrets *injectedSecrets
+	if spec != nil && len(spec.Secrets) > 0 {
+		secrets, err = injectSecrets(execBaseDir(), spec.Secrets, credential)
+		if err != nil {
+			abortExec(executionID, commandArgs, executingUID, executingUser, fmt.Errorf("failed to inject secrets: %w", err))
+		}
+		minimalEnv = mergeEnv(minimalEnv, secrets.env)
+		redact.addValues(secrets.values...)
+	}
+
+	// Stream output to the daemon and accept cancellation for the whole run.
+	// Streaming is best effort: without a daemon the job runs as before and
+	// only the final reports are sent.
+	run := &jobRun{
+		args:          commandArgs,
+		env:           minimalEnv,
+		dir:           workDir,
+		credential:    credential,
+		lock:          lock,
+		limits:        limits,
+		limitsSpec:    execLimits,
+		secretValues:  redact.values,
+		executingUID:  executingUID,
+		executingUser: executingUser,
+		warning:       securityWarning,
+	}
+	if execTimeout > 0 {
+		run.deadline = time.Now().Add(time.Duration(execTimeout) * time.Second)
+	}
+	var cancel <-chan cancelRequest
+	if session, err := openExecSession(execSocket()); err == nil {
+		run.session = session
+		cancel = session.watchCancel()
+	}
+	run.stop = watchStop(cancel, terminate)
+
+	// Run the command, repeating failed attempts if the job allows retries.
+	// Every attempt is reported separately; all share the first attempt's
+	// execution ID as their run ID.
+	runID := executionID
+	var report protocol.ExecutionReportPayload
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 {
+			executionID = newExecutionID()
+		}
+		report = run.attempt(executionID)
+		report.RunID = runID
+		report.Attempt = attempt
+		if attempt == 1 {
+			report.StartDelayMs = int(delay.Milliseconds())
+		}
+
+		// Log for local audit trail
+		log.Printf("Job executed: job=%s execution=%s attempt=%d user=%s uid=%d exit=%d signal=%s cmd=%q",
+			execJobID, executionID, attempt, executingUser, executingUID, report.ExitCode, report.Signal, report.Command)
+
+		reportToDaemon(report)
+
+		if attempt > execRetries || !shouldRetry(report, execRetryOn) {
+			break
+		}
+		backoff := retryBackoff(execRetryDelay, attempt)
+		if !run.deadline.IsZero() && time.Now().Add(backoff).After(run.deadline) {
+			log.Printf("Job %s failed with exit code %d, not retrying: the retry would start after the timeout", execJobID, report.ExitCode)
+			break
+		}
+		log.Printf("Job %s failed with exit code %d, retrying in %v (%d of %d retries left)",
+			execJobID, report.ExitCode, backoff, execRetries-attempt+1, execRetries)
+		if req, ok := run.waitRetry(backoff); !ok {
+			// Report the retry that never started, so the run visibly ends.
+			log.Printf("Job %s retry cancelled by %q", execJobID, req.CancelledBy)
+			reportToDaemon(protocol.ExecutionReportPayload{
+				ExecutionID:   newExecutionID(),
+				JobID:         execJobID,
+				Command:       strings.Join(commandArgs, " "),
+				ExitCode:      report.ExitCode,
+				ExecutingUID:  executingUID,
+				ExecutingUser: executingUser,
+				Warning:       securityWarning,
+				Stderr:        "Retry cancelled before it started",
+				StartTime:     time.Now().Format(time.RFC3339),
+				Status:        statusCancelled,
+				Trigger:       execTrigger,
+				CancelledBy:   req.CancelledBy,
+				RunID:         runID,
+				Attempt:       attempt + 1,
+			})
+			break
+		}
+	}
+
+	// Secret files must not outlive the run (deferred calls do not run on os.Exit).
+	if secrets != nil {
+		secrets.cleanup()
+	}
+	if run.session != nil {
+		run.session.close()
+	}
+
+	// Exit with the same code as the last attempt of the wrapped command
+	os.Exit(report.ExitCode)
+}
+
+// jobRun holds what every attempt of a run needs to start the command.
+type jobRun struct {
+	args          []string
+	env           []string
+	dir           string
+	credential    *syscall.Credential
+	lock          *jobLock
+	limits        []rlimit
+	limitsSpec    string   // limits in --limits form, for the apply-limits helper
+	secretValues  []string // Injected secret values to redact from output
+	executingUID  int
+	executingUser string
+	warning       string
+
+	session  *execSession         // Live session with the daemon, nil if unreachable
+	stop     <-chan cancelRequest // Cancellation from the daemon, or SIGTERM
+	deadline time.Time            // End of the whole run including retries; zero = no timeout
+}
+
+// watchStop merges cancel requests from the daemon and SIGTERM, e.g. from a
+// replacing run, into one channel. Either stops the current attempt and any
+// pending retries.
+func watchStop(cancel <-chan cancelRequest, terminate <-chan os.Signal) <-chan cancelRequest {
+	stop := make(chan cancelRequest, 1)
+	go func() {
+		select {
+		case req := <-cancel:
+			stop <- req
+		case sig := <-terminate:
+			stop <- cancelRequest{CancelledBy: "signal " + signalName(sig.(syscall.Signal))}
+		}
+	}()
+	return stop
+}
+
+// waitRetry waits out the backoff before a retry, keeping the daemon session
+// alive. It returns false with the request if the run is stopped meanwhile.
+func (r *jobRun) waitRetry(backoff time.Duration) (cancelRequest, bool) {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	keepalive := time.NewTicker(streamKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return cancelRequest{}, true
+		case req := <-r.stop:
+			return req, false
+		case <-keepalive.C:
+			if r.session != nil {
+				r.session.send("keepalive", nil)
+			}
+		}
+	}
+}
+
+// attempt runs the command once and returns its report.
+func (r *jobRun) attempt(executionID string) protocol.ExecutionReportPayload {
+	redact := redactor{values: r.secretValues}
+	commandArgs := r.args
+
 	// Execute the command
 	startTime := time.Now()
 
 	stdout := newLimitedBuffer()
 	stderr := newLimitedBuffer()
 	execCmd := exec.Command(commandArgs[0], commandArgs[1:]...)
+	if len(r.limits) > 0 {
+		// Limits are applied by a helper that then executes the command in place.
+		helperArgs := append([]string{"apply-limits", "--limits", r.limitsSpec, "--"}, commandArgs...)
+		execCmd = exec.Command(agentExecutable(), helperArgs...)
+	}
 	execCmd.Stdout = stdout
 	execCmd.Stderr = stderr
-	execCmd.Env = minimalEnv
-	execCmd.Dir = workDir
 
-	err = execCmd.Run()
+	// Stream output to the daemon while the job runs.
+	var streamer *outputStreamer
+	if r.session != nil {
+		streamer = newOutputStreamer(r.session, executionID, &redactor{values: redact.values})
+		execCmd.Stdout = io.MultiWriter(stdout, streamer.writer("stdout"))
+		execCmd.Stderr = io.MultiWriter(stderr, streamer.writer("stderr"))
+	}
+	execCmd.Env = r.env
+	execCmd.Dir = r.dir
+	if r.credential != nil {
+		execCmd.SysProcAttr = &syscall.SysProcAttr{Credential: r.credential}
+	}
+
+	// Run the job in its own process group so a timeout or cancellation can stop
+	// the whole tree.
+	timedOut := false
+	cancelled := false
+	var cancelledBy string
+	proc, err := startJobProcess(execCmd)
+	if err == nil {
+		if r.lock != nil {
+			r.lock.setHolder(execCmd.Process.Pid)
+		}
+		if streamer != nil {
+			started := protocol.ExecutionStartedPayload{
+				ExecutionID: executionID,
+				JobID:       execJobID,
+				Command:     strings.Join(commandArgs, " "),
+				StartTime:   startTime.Format(time.RFC3339),
+				Trigger:     execTrigger,
+			}
+			if err := r.session.send("execution_started", started); err != nil {
+				streamer.fail(err)
+			}
+			go streamer.run()
+		}
+
+		// Wait for the job to exit, time out, or be stopped. With retries, the
+		// timeout bounds the whole run, so later attempts get what is left.
+		var timeout <-chan time.Time
+		if !r.deadline.IsZero() {
+			timeout = time.After(time.Until(r.deadline))
+		}
+		select {
+		case <-proc.done:
+		case <-timeout:
+			timedOut = true
+			log.Printf("Job %s exceeded timeout of %ds, terminating", execJobID, execTimeout)
+			proc.terminate(killGracePeriod)
+		case req := <-r.stop:
+			cancelled = true
+			cancelledBy = req.CancelledBy
+			log.Printf("Job %s cancelled by %q, terminating", execJobID, cancelledBy)
+			proc.terminate(killGracePeriod)
+		}
+		err = proc.wait()
+		if r.lock != nil {
+			// The group ID may be reused once the job has exited.
+			r.lock.setHolder(0)
+		}
+	}
 
 	duration := time.Since(startTime)
 	exitCode := 0
 
-	if err != nil {
+	if streamer != nil && proc != nil {
+		streamer.close()
+	}
+
+	outcome := inspectProcessState(execCmd.ProcessState)
+
+	if timedOut {
+		exitCode = timeoutExitCode
+		stderr.WriteString(fmt.Sprintf("\nExecution timed out after %ds", execTimeout))
+	} else if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
+			// -1 for signalled processes; the signal itself is reported separately.
 			exitCode = exitError.ExitCode()
 		} else {
 			exitCode = 1
 			stderr.WriteString(fmt.Sprintf("\nExecution error: %v", err))
 		}
 	}
+	if cancelled {
+		// The exit code is whatever the job returned after being asked to stop.
+		stderr.WriteString("\nExecution cancelled")
+	}
 
 	// Create execution report with full audit information.
 	// SECURITY: Log exact command, timestamp, UID, and exit status for auditability.
 	// Commands are NOT redacted or rewritten.
 	report := protocol.ExecutionReportPayload{
+		ExecutionID:   executionID,
 		JobID:         execJobID,
 		Command:       strings.Join(commandArgs, " "),
 		ExitCode:      exitCode,
-		ExecutingUID:  executingUID,
-		ExecutingUser: executingUser,
-		Warning:       securityWarning,
-		Stdout:        stdout.String(),
-		Stderr:        stderr.String(),
+		ExecutingUID:  r.executingUID,
+		ExecutingUser: r.executingUser,
+		Warning:       r.warning,
+		Stdout:        redact.redact(stdout.String()),
+		Stderr:        redact.redact(stderr.String()),
 		StartTime:     startTime.Format(time.RFC3339),
 		DurationMs:    int(duration.Milliseconds()),
+		TimedOut:      timedOut,
+		Trigger:       execTrigger,
+		CoreDumped:    outcome.coreDumped,
+		Rusage:        outcome.usage,
+		Redactions:    redact.count,
+	}
+	if outcome.signal != 0 {
+		report.Signal = signalName(outcome.signal)
 	}
+	if !timedOut && !cancelled {
+		report.LimitExceeded = limitExceeded(r.limits, outcome)
+	}
+	if cancelled {
+		report.Status = statusCancelled
+		report.CancelledBy = cancelledBy
+	}
+	return report
+}
 
-	// Log for local audit trail
-	log.Printf("Job executed: job=%s user=%s uid=%d exit=%d cmd=%q",
-		execJobID, executingUser, executingUID, exitCode, report.Command)
+// shouldRetry reports whether a failed attempt may be retried. Cancelled runs
+// are never retried. If retryOn is empty, any non-zero exit code is retried.
+func shouldRetry(report protocol.ExecutionReportPayload, retryOn []int) bool {
+	if report.ExitCode == 0 || report.Status == statusCancelled {
+		return false
+	}
+	if len(retryOn) == 0 {
+		return true
+	}
+	for _, code := range retryOn {
+		if report.ExitCode == code {
+			return true
+		}
+	}
+	return false
+}
 
-	// Send to daemon via Unix socket
-	if err := sendToDaemon(report); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to send report to daemon: %v\n", err)
+// retryBackoff returns the delay before the retry following attempt: the base
+// delay, doubled after every further attempt and capped at maxRetryBackoff.
+func retryBackoff(delaySeconds, attempt int) time.Duration {
+	backoff := time.Duration(delaySeconds) * time.Second
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
 	}
+	return min(backoff, maxRetryBackoff)
+}
 
-	// Exit with the same code as the wrapped command
-	os.Exit(exitCode)
+// startDelay returns a delay in [0, jitterSeconds) derived from the hostname
+// and job ID, so that hosts running the same job start at different times but
+// each host always uses the same offset.
+func startDelay(hostname, jobID string, jitterSeconds int) time.Duration {
+	if jitterSeconds <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(hostname))
+	h.Write([]byte{0})
+	h.Write([]byte(jobID))
+	window := uint64(time.Duration(jitterSeconds) * time.Second / time.Millisecond)
+	return time.Duration(h.Sum64()%window) * time.Millisecond
 }
 
-func sendToDaemon(report protocol.ExecutionReportPayload) error {
-	path := socketPath
+// abortExec reports a run that failed before the command could be started, then exits.
+func abortExec(executionID string, commandArgs []string, uid int, userName string, err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	reportToDaemon(protocol.ExecutionReportPayload{
+		ExecutionID:   executionID,
+		JobID:         execJobID,
+		Command:       strings.Join(commandArgs, " "),
+		ExitCode:      1,
+		ExecutingUID:  uid,
+		ExecutingUser: userName,
+		Stderr:        fmt.Sprintf("Execution error: %v", err),
+		StartTime:     time.Now().Format(time.RFC3339),
+		Trigger:       execTrigger,
+	})
+	os.Exit(1)
+}
+
+// execBaseDir returns the agent state directory as seen by exec mode.
+func execBaseDir() string {
+	if execStateDir != "" {
+		return execStateDir
+	}
+	return stateDir
+}
+
+// reportToDaemon sends a report via the Unix socket, falling back to the spool
+// if the daemon is unreachable.
+func reportToDaemon(report protocol.ExecutionReportPayload) {
+	if err := sendToDaemon(report); err != nil {
+		if spoolErr := spoolReport(spoolDir(execBaseDir()), report); spoolErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: Failed to send report to daemon: %v; spooling also failed: %v\n", err, spoolErr)
+		} else {
+			log.Printf("Daemon unreachable (%v); report spooled for later delivery", err)
+		}
+	}
+}
+
+// execSocket returns the daemon socket path as seen by exec mode.
+func execSocket() string {
 	if execSocketPath != "" {
-		path = execSocketPath
+		return execSocketPath
 	}
-	conn, err := net.Dial("unix", path)
+	return socketPath
+}
+
+func sendToDaemon(report protocol.ExecutionReportPayload) error {
+	conn, err := net.Dial("unix", execSocket())
 	if err != nil {
 		return fmt.Errorf("failed to connect to daemon socket: %w", err)
 	}
@@ -0,0 +1,276 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+const (
+	// streamFlushInterval is how often buffered output is sent to the daemon.
+	streamFlushInterval = time.Second
+	// streamKeepaliveInterval keeps an idle session alive; it must stay well
+	// below the daemon's streamIdleTimeout.
+	streamKeepaliveInterval = 30 * time.Second
+	// maxStreamChunk is the largest chunk sent at once; partial lines are only
+	// sent once this much output is pending without a newline.
+	maxStreamChunk = 16 * 1024
+	// maxStreamPending bounds output buffered per stream while the daemon is slow.
+	// Output beyond this is dropped from the live stream (never from the report).
+	maxStreamPending   = 64 * 1024
+	streamWriteTimeout = 5 * time.Second
+)
+
+// socketMessage frames messages on the daemon's Unix socket. Bare execution
+// reports without a type are still accepted for older exec wrappers.
+type socketMessage struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// execSession is a long-lived connection from exec mode to the daemon for the
+// duration of one run, including all retry attempts. Writes are serialized by mu.
+type execSession struct {
+	conn net.Conn
+	mu   sync.Mutex
+	enc  *json.Encoder
+}
+
+// openExecSession connects to the daemon and opens the session with a keepalive.
+// The daemon drops connections that stay silent for its short socket read
+// timeout, so the session must be announced before anything that may take
+// longer, e.g. a first attempt that fails to start followed by a retry delay.
+func openExecSession(path string) (*execSession, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &execSession{conn: conn, enc: json.NewEncoder(conn)}
+	if err := s.send("keepalive", nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// send writes one framed message. It never blocks longer than streamWriteTimeout.
+func (s *execSession) send(msgType string, payload interface{}) error {
+	var raw json.RawMessage
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		raw = data
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	return s.enc.Encode(socketMessage{Type: msgType, Payload: raw})
+}
+
+// cancelRequest is sent by the daemon over an exec session to stop the job.
+type cancelRequest struct {
+	CancelledBy string `json:"cancelledBy,omitempty"`
+}
+
+// watchCancel reads commands from the daemon and delivers the first cancel
+// request on the returned channel. The reader stops when the session closes.
+func (s *execSession) watchCancel() <-chan cancelRequest {
+	ch := make(chan cancelRequest, 1)
+	go func() {
+		decoder := json.NewDecoder(io.LimitReader(s.conn, maxReportSize))
+		for {
+			var msg socketMessage
+			if err := decoder.Decode(&msg); err != nil {
+				return
+			}
+			if msg.Type != "cancel" {
+				continue
+			}
+			var req cancelRequest
+			json.Unmarshal(msg.Payload, &req)
+			select {
+			case ch <- req:
+			default:
+			}
+		}
+	}()
+	return ch
+}
+
+func (s *execSession) close() {
+	s.conn.Close()
+}
+
+// outputStreamer forwards job output to the daemon while the job runs.
+// Writes from the job never block on the network: output is buffered per stream
+// and flushed periodically by run. If the daemon falls behind, the buffer is
+// capped and the excess is dropped; the next chunk reports how much was lost.
+type outputStreamer struct {
+	session     *execSession
+	executionID string
+	redact      *redactor
+
+	mu       sync.Mutex
+	pending  map[string]*bytes.Buffer
+	dropped  map[string]int64
+	seq      int64
+	lastSend time.Time
+	broken   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newOutputStreamer(session *execSession, executionID string, redact *redactor) *outputStreamer {
+	return &outputStreamer{
+		session:     session,
+		executionID: executionID,
+		redact:      redact,
+		pending:     map[string]*bytes.Buffer{"stdout": {}, "stderr": {}},
+		dropped:     map[string]int64{},
+		lastSend:    time.Now(),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// writer returns an io.Writer feeding the named stream ("stdout" or "stderr").
+func (s *outputStreamer) writer(stream string) io.Writer {
+	return streamWriter{s: s, stream: stream}
+}
+
+type streamWriter struct {
+	s      *outputStreamer
+	stream string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	// Always report the full length: dropping live output must not fail the job's writes.
+	n := len(p)
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.broken {
+		return n, nil
+	}
+	buf := s.pending[w.stream]
+	room := maxStreamPending - buf.Len()
+	if room < len(p) {
+		if room < 0 {
+			room = 0
+		}
+		s.dropped[w.stream] += int64(len(p) - room)
+		p = p[:room]
+	}
+	buf.Write(p)
+	return n, nil
+}
+
+// run flushes buffered output until close is called.
+func (s *outputStreamer) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(streamFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(false)
+		case <-s.stop:
+			s.flush(true)
+			return
+		}
+	}
+}
+
+// close sends any remaining output and stops the flush loop.
+func (s *outputStreamer) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// flush sends complete lines (or everything, if final) from each stream.
+// Only whole lines are sent where possible so that redaction sees values intact.
+func (s *outputStreamer) flush(final bool) {
+	type chunk struct {
+		stream  string
+		data    []byte
+		dropped int64
+	}
+	var chunks []chunk
+
+	s.mu.Lock()
+	if s.broken {
+		s.mu.Unlock()
+		return
+	}
+	for _, stream := range []string{"stdout", "stderr"} {
+		buf := s.pending[stream]
+		for buf.Len() > 0 {
+			n := buf.Len()
+			if n > maxStreamChunk {
+				n = maxStreamChunk
+			}
+			if !final && n == buf.Len() && n < maxStreamChunk {
+				// Hold back a trailing partial line until it completes.
+				i := bytes.LastIndexByte(buf.Bytes()[:n], '\n')
+				if i < 0 {
+					break
+				}
+				n = i + 1
+			}
+			data := make([]byte, n)
+			buf.Read(data)
+			chunks = append(chunks, chunk{stream: stream, data: data, dropped: s.dropped[stream]})
+			s.dropped[stream] = 0
+		}
+	}
+	keepalive := len(chunks) == 0 && time.Since(s.lastSend) >= streamKeepaliveInterval
+	s.mu.Unlock()
+
+	// Network writes happen outside the lock so the job's writes never wait on them.
+	for _, c := range chunks {
+		s.seq++
+		payload := protocol.ExecutionOutputPayload{
+			ExecutionID:  s.executionID,
+			Seq:          s.seq,
+			Stream:       c.stream,
+			Data:         s.redact.redact(string(c.data)),
+			DroppedBytes: c.dropped,
+		}
+		if err := s.session.send("execution_output", payload); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+	if keepalive {
+		if err := s.session.send("keepalive", nil); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+	if len(chunks) > 0 || keepalive {
+		s.mu.Lock()
+		s.lastSend = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+// fail disables streaming for the rest of the run. The final report is
+// delivered independently, so losing the live stream loses no results.
+func (s *outputStreamer) fail(err error) {
+	log.Printf("Warning: live output streaming stopped: %v", err)
+	s.mu.Lock()
+	s.broken = true
+	s.mu.Unlock()
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// newTestStreamer returns a streamer writing to a pipe, and a function reading
+// the next execution_output payload from the daemon's end.
+func newTestStreamer(t *testing.T) (*outputStreamer, func() protocol.ExecutionOutputPayload) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	session := &execSession{conn: client, enc: json.NewEncoder(client)}
+	s := newOutputStreamer(session, "exec-1", &redactor{})
+
+	decoder := json.NewDecoder(server)
+	next := func() protocol.ExecutionOutputPayload {
+		t.Helper()
+		server.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg socketMessage
+		if err := decoder.Decode(&msg); err != nil {
+			t.Fatalf("reading chunk failed: %v", err)
+		}
+		var chunk protocol.ExecutionOutputPayload
+		if msg.Type != "execution_output" || json.Unmarshal(msg.Payload, &chunk) != nil {
+			t.Fatalf("unexpected message %s: %s", msg.Type, msg.Payload)
+		}
+		return chunk
+	}
+	return s, next
+}
+
+func TestOutputStreamer_SeqOrdering(t *testing.T) {
+	s, next := newTestStreamer(t)
+	stdout, stderr := s.writer("stdout"), s.writer("stderr")
+
+	stdout.Write([]byte("one\ntw"))
+	stderr.Write([]byte("err\n"))
+	go s.flush(false)
+	first, second := next(), next()
+	if first.Seq != 1 || first.Stream != "stdout" || first.Data != "one\n" {
+		t.Errorf("first chunk = %+v, want seq 1 with the complete stdout line", first)
+	}
+	if second.Seq != 2 || second.Stream != "stderr" || second.Data != "err\n" {
+		t.Errorf("second chunk = %+v, want seq 2 from stderr", second)
+	}
+
+	// The partial line is held back until the final flush.
+	stdout.Write([]byte("o"))
+	go s.flush(true)
+	if third := next(); third.Seq != 3 || third.Data != "two" {
+		t.Errorf("third chunk = %+v, want seq 3 with the rest of the line", third)
+	}
+}
+
+func TestOutputStreamer_DropAccounting(t *testing.T) {
+	s, next := newTestStreamer(t)
+	w := s.writer("stdout")
+
+	// Nobody is reading: output beyond the buffer cap is dropped, not blocked on.
+	line := strings.Repeat("x", 1023) + "\n"
+	written := 0
+	for written < maxStreamPending+10*len(line) {
+		n, err := w.Write([]byte(line))
+		if err != nil || n != len(line) {
+			t.Fatalf("Write() = %d, %v; want the full length", n, err)
+		}
+		written += n
+	}
+
+	go s.flush(true)
+	var received int
+	var dropped int64
+	for received < maxStreamPending {
+		chunk := next()
+		if received > 0 && chunk.DroppedBytes != 0 {
+			t.Errorf("chunk %d repeats the drop count %d", chunk.Seq, chunk.DroppedBytes)
+		}
+		received += len(chunk.Data)
+		dropped += chunk.DroppedBytes
+	}
+	if received != maxStreamPending || dropped != int64(written-maxStreamPending) {
+		t.Errorf("received %d and dropped %d of %d bytes, want %d received", received, dropped, written, maxStreamPending)
+	}
+}
+
+func TestOutputStreamer_Backpressure(t *testing.T) {
+	s, next := newTestStreamer(t)
+	w := s.writer("stdout")
+	w.Write([]byte("first\n"))
+
+	// The flush blocks on the unread pipe; the job's writes must not wait for it.
+	flushed := make(chan struct{})
+	go func() {
+		s.flush(false)
+		close(flushed)
+	}()
+	for {
+		s.mu.Lock()
+		taken := s.pending["stdout"].Len() == 0
+		s.mu.Unlock()
+		if taken {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	wrote := make(chan struct{})
+	go func() {
+		w.Write([]byte("second\n"))
+		close(wrote)
+	}()
+	select {
+	case <-wrote:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked while the daemon was not reading")
+	}
+
+	if chunk := next(); chunk.Data != "first\n" {
+		t.Errorf("chunk = %+v, want the first line", chunk)
+	}
+	<-flushed
+	go s.flush(false)
+	if chunk := next(); chunk.Seq != 2 || chunk.Data != "second\n" {
+		t.Errorf("chunk = %+v, want the second line as seq 2", chunk)
+	}
+}
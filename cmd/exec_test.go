@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestStartDelay(t *testing.T) {
+	if d := startDelay("web-1", "job", 0); d != 0 {
+		t.Errorf("startDelay without jitter = %v, want 0", d)
+	}
+
+	// The delay is stable for a host and job.
+	if a, b := startDelay("web-1", "job", 300), startDelay("web-1", "job", 300); a != b {
+		t.Errorf("startDelay not deterministic: %v != %v", a, b)
+	}
+
+	// Across a fleet, delays stay within the window and are spread out.
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := startDelay(fmt.Sprintf("web-%d", i), "job", 300)
+		if d < 0 || d >= 300*time.Second {
+			t.Fatalf("startDelay = %v, want within [0, 300s)", d)
+		}
+		distinct[d.Truncate(time.Second)] = true
+	}
+	if len(distinct) < 50 {
+		t.Errorf("only %d distinct delays across 100 hosts", len(distinct))
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		report  protocol.ExecutionReportPayload
+		retryOn []int
+		want    bool
+	}{
+		{protocol.ExecutionReportPayload{ExitCode: 0}, nil, false},
+		{protocol.ExecutionReportPayload{ExitCode: 1}, nil, true},
+		{protocol.ExecutionReportPayload{ExitCode: timeoutExitCode, TimedOut: true}, nil, true},
+		{protocol.ExecutionReportPayload{ExitCode: 143, Status: statusCancelled}, nil, false},
+		{protocol.ExecutionReportPayload{ExitCode: 75}, []int{75, 111}, true},
+		{protocol.ExecutionReportPayload{ExitCode: 2}, []int{75, 111}, false},
+	}
+	for _, tt := range tests {
+		if got := shouldRetry(tt.report, tt.retryOn); got != tt.want {
+			t.Errorf("shouldRetry(exit %d, status %q, %v) = %v, want %v",
+				tt.report.ExitCode, tt.report.Status, tt.retryOn, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		delay, attempt int
+		want           time.Duration
+	}{
+		{0, 1, 0},
+		{10, 1, 10 * time.Second},
+		{10, 2, 20 * time.Second},
+		{10, 4, 80 * time.Second},
+		{600, 5, maxRetryBackoff},
+		{3600, 10, maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		if got := retryBackoff(tt.delay, tt.attempt); got != tt.want {
+			t.Errorf("retryBackoff(%d, %d) = %v, want %v", tt.delay, tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestWaitRetry_CancelDuringBackoff(t *testing.T) {
+	cancel := make(chan cancelRequest, 1)
+	terminate := make(chan os.Signal, 1)
+	run := &jobRun{stop: watchStop(cancel, terminate)}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel <- cancelRequest{CancelledBy: "alice"}
+	}()
+
+	start := time.Now()
+	req, ok := run.waitRetry(time.Hour)
+	if ok || req.CancelledBy != "alice" {
+		t.Errorf("waitRetry() = (%v, %v), want cancelled by alice", req, ok)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waitRetry() returned after %v, want promptly", elapsed)
+	}
+}
+
+func TestWaitRetry_SIGTERMDuringBackoff(t *testing.T) {
+	terminate := make(chan os.Signal, 1)
+	run := &jobRun{stop: watchStop(nil, terminate)}
+	terminate <- syscall.SIGTERM
+
+	req, ok := run.waitRetry(time.Hour)
+	if ok || req.CancelledBy != "signal SIGTERM" {
+		t.Errorf("waitRetry() = (%v, %v), want stopped by SIGTERM", req, ok)
+	}
+}
+
+func TestWaitRetry_Elapses(t *testing.T) {
+	run := &jobRun{stop: watchStop(nil, nil)}
+	if _, ok := run.waitRetry(10 * time.Millisecond); !ok {
+		t.Error("waitRetry() stopped without a request")
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// maxJobSpecSize bounds a job spec file read by exec mode.
+const maxJobSpecSize = 1024 * 1024
+
+// envNamePattern matches portable environment variable names.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jobSpec carries per-job runtime settings from the daemon to exec mode.
+// It is written to a private file rather than the cron file so that
+// environment values (which may be credentials) never appear in cron.d or ps.
+type jobSpec struct {
+	JobID      string               `json:"jobId"`
+	Env        map[string]string    `json:"env,omitempty"`
+	WorkingDir string               `json:"workingDir,omitempty"`
+	Secrets    []protocol.SecretRef `json:"secrets,omitempty"` // References only; values stay in the secret store
+}
+
+// jobSpecDir returns the directory holding per-job spec files.
+func jobSpecDir(baseDir string) string {
+	return filepath.Join(baseDir, "jobs")
+}
+
+// jobSpecPath returns the spec file for a job.
+func jobSpecPath(baseDir, jobID string) string {
+	return filepath.Join(jobSpecDir(baseDir), jobFileName(jobID)+".json")
+}
+
+// jobNeedsSpec reports whether a job has settings that are passed via a spec file.
+func jobNeedsSpec(job protocol.JobDefinition) bool {
+	return len(job.Env) > 0 || job.WorkingDir != "" || len(job.Secrets) > 0
+}
+
+// validateJobSpec checks a job's environment and working directory.
+func validateJobSpec(job protocol.JobDefinition) error {
+	for name, value := range job.Env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if strings.ContainsRune(value, 0) {
+			return fmt.Errorf("environment variable %q contains a NUL byte", name)
+		}
+	}
+	if job.WorkingDir != "" {
+		dir := job.WorkingDir
+		if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir || strings.ContainsAny(dir, "\x00\n\r") {
+			return fmt.Errorf("workingDir must be a clean absolute path")
+		}
+	}
+	return validateSecretRefs(job.Secrets)
+}
+
+// writeJobSpecs writes spec files for jobs that need them and removes spec files
+// of jobs that no longer do.
+func writeJobSpecs(baseDir string, jobs []protocol.JobDefinition) error {
+	dir := jobSpecDir(baseDir)
+	// SECURITY: Spec files may contain credentials; keep them private.
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create job spec directory: %w", err)
+	}
+
+	wanted := make(map[string]bool)
+	for _, job := range jobs {
+		if !jobNeedsSpec(job) {
+			continue
+		}
+		path := jobSpecPath(baseDir, job.JobID)
+		wanted[filepath.Base(path)] = true
+
+		data, err := json.Marshal(jobSpec{JobID: job.JobID, Env: job.Env, WorkingDir: job.WorkingDir, Secrets: job.Secrets})
+		if err != nil {
+			return err
+		}
+		tmpFile := path + ".tmp"
+		if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+			return fmt.Errorf("failed to write job spec for %q: %w", job.JobID, err)
+		}
+		if err := os.Rename(tmpFile, path); err != nil {
+			os.Remove(tmpFile)
+			return fmt.Errorf("failed to write job spec for %q: %w", job.JobID, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !wanted[e.Name()] {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				log.Printf("Failed to remove stale job spec %s: %v", e.Name(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadJobSpec reads the spec file for jobID.
+func loadJobSpec(path, jobID string) (*jobSpec, error) {
+	// SECURITY: Do not follow symlinks and bound the read size.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// SECURITY: The spec may hold credentials and decides what the job runs
+	// with, so it must be a private file written by the daemon's user or root.
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("job spec %s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("job spec %s is accessible to other users (mode %v)", path, info.Mode().Perm())
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() && st.Uid != 0 {
+		return nil, fmt.Errorf("job spec %s is owned by uid %d", path, st.Uid)
+	}
+
+	var spec jobSpec
+	if err := json.NewDecoder(io.LimitReader(f, maxJobSpecSize)).Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid job spec: %w", err)
+	}
+	if spec.JobID != jobID {
+		return nil, fmt.Errorf("job spec is for job %q, not %q", spec.JobID, jobID)
+	}
+	if err := validateSecretRefs(spec.Secrets); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// mergeEnv overlays job-specific variables onto the base environment.
+// Variables in env replace base entries with the same name.
+func mergeEnv(base []string, env map[string]string) []string {
+	if len(env) == 0 {
+		return base
+	}
+	merged := make([]string, 0, len(base)+len(env))
+	for _, kv := range base {
+		name := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			name = kv[:i]
+		}
+		if _, overridden := env[name]; !overridden {
+			merged = append(merged, kv)
+		}
+	}
+	for name, value := range env {
+		merged = append(merged, name+"="+value)
+	}
+	return merged
+}
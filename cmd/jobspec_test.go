@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestValidateJobSpec_WorkingDir(t *testing.T) {
+	for dir, valid := range map[string]bool{
+		"/srv/app":        true,
+		"/":               true,
+		"srv/app":         false,
+		"/srv/../etc":     false,
+		"/srv/app/":       false,
+		"/srv//app":       false,
+		"/srv/app\n* * *": false,
+	} {
+		err := validateJobSpec(protocol.JobDefinition{WorkingDir: dir})
+		if (err == nil) != valid {
+			t.Errorf("validateJobSpec(workingDir %q) = %v, want valid %v", dir, err, valid)
+		}
+	}
+}
+
+func TestLoadJobSpec(t *testing.T) {
+	dir := t.TempDir()
+	jobs := []protocol.JobDefinition{{JobID: "j", Env: map[string]string{"TOKEN": "s3cret"}}}
+	if err := writeJobSpecs(dir, jobs); err != nil {
+		t.Fatalf("writeJobSpecs failed: %v", err)
+	}
+	path := jobSpecPath(dir, "j")
+
+	spec, err := loadJobSpec(path, "j")
+	if err != nil || spec.Env["TOKEN"] != "s3cret" {
+		t.Fatalf("loadJobSpec() = %+v, %v", spec, err)
+	}
+	if _, err := loadJobSpec(path, "other"); err == nil {
+		t.Error("loadJobSpec() accepted the spec of another job")
+	}
+
+	// A symlink is not followed, even to a valid spec.
+	link := filepath.Join(dir, "link.json")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadJobSpec(link, "j"); err == nil {
+		t.Error("loadJobSpec() followed a symlink")
+	}
+
+	// A spec readable by other users may have been tampered with or leaked.
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadJobSpec(path, "j"); err == nil || !strings.Contains(err.Error(), "accessible to other users") {
+		t.Errorf("loadJobSpec() of a mode 0644 spec = %v, want rejected", err)
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Concurrency policies for overlapping runs of the same job.
+const (
+	concurrencyAllow   = "allow"   // Start a new run regardless (default)
+	concurrencyForbid  = "forbid"  // Skip the new run while one is active
+	concurrencyReplace = "replace" // Kill the active run, then start the new one
+)
+
+const (
+	// replaceReportGrace is how long a replaced wrapper gets to report and exit
+	// after its job's own grace period.
+	replaceReportGrace = 5 * time.Second
+	// replaceLockTimeout bounds how long a replacing run waits for the old run's
+	// wrapper to report and release the lock.
+	replaceLockTimeout = killGracePeriod + 30*time.Second
+)
+
+// errJobLocked is returned when another run of the job holds the lock.
+var errJobLocked = errors.New("another run of this job is still active")
+
+func validConcurrencyPolicy(policy string) bool {
+	switch policy {
+	case "", concurrencyAllow, concurrencyForbid, concurrencyReplace:
+		return true
+	}
+	return false
+}
+
+// jobLock is an exclusive flock on a per-job lock file in the state directory.
+// The holder records its own PID and, while the job runs, the job's process
+// group ID, so that a replacing run can stop it. The lock is released when
+// the wrapper exits.
+type jobLock struct {
+	f *os.File
+}
+
+// jobFileName returns a file name stem for per-job state files. Job IDs are
+// server-controlled and may contain any character, so the name is a hash.
+func jobFileName(jobID string) string {
+	sum := sha256.Sum256([]byte(jobID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// jobLockPath returns the lock file for a job.
+func jobLockPath(baseDir, jobID string) string {
+	return filepath.Join(baseDir, "locks", jobFileName(jobID)+".lock")
+}
+
+// acquireJobLock takes the job lock according to policy. With "forbid" it returns
+// errJobLocked if the lock is held; with "replace" it terminates the holder's
+// process group and waits for the lock.
+func acquireJobLock(baseDir, jobID, policy string) (*jobLock, error) {
+	path := jobLockPath(baseDir, jobID)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	err = tryLock(f)
+	if err == nil {
+		return newJobLock(f), nil
+	}
+	if err != errJobLocked || policy != concurrencyReplace {
+		f.Close()
+		return nil, err
+	}
+
+	// Replace: ask the running wrapper to stop its job, then wait for it to
+	// report and release the lock. The wrapper gives its job killGracePeriod to
+	// exit; if it does not release the lock shortly after, the job group and the
+	// wrapper are killed. The wrapper is signalled rather than the job because it
+	// may be between attempts, with no job process at all.
+	if pid, _ := readLockHolder(f); isAgentProcess(pid) {
+		log.Printf("Replacing active run of job %s (wrapper PID %d)", jobID, pid)
+		syscall.Kill(pid, syscall.SIGTERM)
+	}
+
+	deadline := time.Now().Add(replaceLockTimeout)
+	killAt := time.Now().Add(killGracePeriod + replaceReportGrace)
+	killed := false
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if err := tryLock(f); err == nil {
+			return newJobLock(f), nil
+		} else if err != errJobLocked {
+			f.Close()
+			return nil, err
+		}
+		if !killed && time.Now().After(killAt) {
+			pid, pgid := readLockHolder(f)
+			if jobGroupAlive(pid, pgid) {
+				syscall.Kill(-pgid, syscall.SIGKILL)
+			}
+			if isAgentProcess(pid) {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+			killed = true
+		}
+	}
+
+	f.Close()
+	return nil, errJobLocked
+}
+
+// newJobLock takes ownership of a locked file. The previous holder's entry is
+// overwritten at once, so it can never be mistaken for the new run's job.
+func newJobLock(f *os.File) *jobLock {
+	l := &jobLock{f: f}
+	l.setHolder(0)
+	return l
+}
+
+func tryLock(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errJobLocked
+	}
+	return err
+}
+
+// readLockHolder returns the holder's wrapper PID and job process group ID
+// (0 while no job process is running).
+func readLockHolder(f *os.File) (pid, pgid int) {
+	buf := make([]byte, 64)
+	n, _ := f.ReadAt(buf, 0)
+	fields := strings.Fields(string(buf[:n]))
+	if len(fields) != 2 {
+		return 0, 0
+	}
+	pid, err1 := strconv.Atoi(fields[0])
+	pgid, err2 := strconv.Atoi(fields[1])
+	if err1 != nil || err2 != nil || pid <= 0 || pgid < 0 {
+		return 0, 0
+	}
+	return pid, pgid
+}
+
+// jobGroupAlive reports whether pgid is a live process group started by the
+// wrapper pid. A group whose leader has exited, or whose ID was reused by an
+// unrelated process, must never be signalled.
+func jobGroupAlive(pid, pgid int) bool {
+	if pid <= 0 || pgid <= 0 {
+		return false
+	}
+	if leaderGroup, err := syscall.Getpgid(pgid); err != nil || leaderGroup != pgid {
+		return false
+	}
+	if ppid, ok := parentPID(pgid); ok && ppid != pid {
+		return false
+	}
+	return syscall.Kill(-pgid, 0) == nil
+}
+
+// setHolder records the process group of the running job, or 0 once it has
+// exited, together with the wrapper's own PID.
+func (l *jobLock) setHolder(pgid int) {
+	if err := l.f.Truncate(0); err != nil {
+		log.Printf("Warning: failed to update lock file: %v", err)
+		return
+	}
+	holder := strconv.Itoa(os.Getpid()) + " " + strconv.Itoa(pgid) + "\n"
+	if _, err := l.f.WriteAt([]byte(holder), 0); err != nil {
+		log.Printf("Warning: failed to update lock file: %v", err)
+	}
+}
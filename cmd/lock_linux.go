@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package cmd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// parentPID returns the parent of process pid from /proc.
+func parentPID(pid int) (int, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, false
+	}
+	// The command name in field 2 may contain spaces; fields resume after ')'.
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	return ppid, err == nil
+}
+
+// isAgentProcess reports whether pid is a live process running the agent
+// binary, so that a PID left in a lock file and since reused is not signalled.
+func isAgentProcess(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	exe, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/exe")
+	if err != nil {
+		return false
+	}
+	self, err := os.Executable()
+	// A binary replaced by an upgrade shows as deleted in running processes.
+	return err == nil && strings.TrimSuffix(exe, " (deleted)") == self
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package cmd
+
+import "syscall"
+
+// parentPID is not available without /proc; callers fall back to checking
+// that the process group is alive.
+func parentPID(pid int) (int, bool) {
+	return 0, false
+}
+
+// isAgentProcess reports whether pid is a live process. Without /proc, its
+// executable cannot be checked.
+func isAgentProcess(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
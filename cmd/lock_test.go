@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startGroup starts a sleeping process in its own process group.
+func startGroup(t *testing.T) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sleep", "100")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start sleep: %v", err)
+	}
+	t.Cleanup(func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func TestAcquireJobLock_Forbid(t *testing.T) {
+	dir := t.TempDir()
+	first, err := acquireJobLock(dir, "job", concurrencyForbid)
+	if err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+	defer first.f.Close()
+
+	// The holder is recorded as soon as the lock is taken.
+	if pid, pgid := readLockHolder(first.f); pid != os.Getpid() || pgid != 0 {
+		t.Errorf("holder = (%d, %d), want (%d, 0)", pid, pgid, os.Getpid())
+	}
+
+	if _, err := acquireJobLock(dir, "job", concurrencyForbid); err != errJobLocked {
+		t.Errorf("second acquire = %v, want errJobLocked", err)
+	}
+	if other, err := acquireJobLock(dir, "other-job", concurrencyForbid); err != nil {
+		t.Errorf("other job blocked: %v", err)
+	} else {
+		other.f.Close()
+	}
+}
+
+func TestAcquireJobLock_ReplaceIgnoresStaleHolder(t *testing.T) {
+	dir := t.TempDir()
+	holder, err := acquireJobLock(dir, "job", concurrencyReplace)
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	// A holder PID and group reused by an unrelated process.
+	unrelated := startGroup(t)
+	holder.f.Truncate(0)
+	pid := strconv.Itoa(unrelated.Process.Pid)
+	holder.f.WriteAt([]byte(pid+" "+pid+"\n"), 0)
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		holder.f.Close()
+	}()
+	l, err := acquireJobLock(dir, "job", concurrencyReplace)
+	if err != nil {
+		t.Fatalf("replace failed: %v", err)
+	}
+	defer l.f.Close()
+
+	if err := syscall.Kill(unrelated.Process.Pid, 0); err != nil {
+		t.Errorf("unrelated process group was signalled: %v", err)
+	}
+}
+
+// TestLockHolderHelper is not a real test: it is started as a subprocess that
+// holds a job lock like a running wrapper.
+func TestLockHolderHelper(t *testing.T) {
+	dir := os.Getenv("CC_LOCK_HELPER_DIR")
+	if dir == "" {
+		t.Skip("helper process only")
+	}
+	if _, err := acquireJobLock(dir, "job", concurrencyForbid); err != nil {
+		os.Exit(2)
+	}
+	os.Stdout.WriteString("locked\n")
+	select {}
+}
+
+func TestAcquireJobLock_ReplaceStopsWrapper(t *testing.T) {
+	dir := t.TempDir()
+	helper := exec.Command(os.Args[0], "-test.run=^TestLockHolderHelper$")
+	helper.Env = append(os.Environ(), "CC_LOCK_HELPER_DIR="+dir)
+	out, _ := helper.StdoutPipe()
+	if err := helper.Start(); err != nil {
+		t.Fatalf("failed to start helper: %v", err)
+	}
+	defer helper.Process.Kill()
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(out, buf); err != nil || string(buf) != "locked\n" {
+		t.Fatalf("helper did not take the lock: %q %v", buf, err)
+	}
+
+	// The helper is between attempts (no job process); replace must still stop it.
+	start := time.Now()
+	l, err := acquireJobLock(dir, "job", concurrencyReplace)
+	if err != nil {
+		t.Fatalf("replace failed: %v", err)
+	}
+	defer l.f.Close()
+	if elapsed := time.Since(start); elapsed >= killGracePeriod {
+		t.Errorf("replace took %v, want the wrapper to stop on SIGTERM", elapsed)
+	}
+
+	err = helper.Wait()
+	if status, ok := helper.ProcessState.Sys().(syscall.WaitStatus); !ok || status.Signal() != syscall.SIGTERM {
+		t.Errorf("helper exit = %v, want SIGTERM", err)
+	}
+	if pid, pgid := readLockHolder(l.f); pid != os.Getpid() || pgid != 0 {
+		t.Errorf("holder after replace = (%d, %d), want the new run", pid, pgid)
+	}
+}
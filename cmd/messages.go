@@ -12,8 +12,21 @@ type UnifiedMessage struct {
 	AgentID string `json:"agentId"`
 	Reason  string `json:"reason"`
 
+	// ReportAck + RunJob + CancelExecution fields
+	ExecutionID string `json:"executionId"`
+	JobID       string `json:"jobId"`
+	CancelledBy string `json:"cancelledBy"`
+
+	// ExecAdhoc fields
+	Argv           []string `json:"argv"`
+	TimeoutSeconds int      `json:"timeoutSeconds"`
+
 	// SyncJobs fields
-	Jobs []protocol.JobDefinition `json:"jobs"`
+	Jobs           []protocol.JobDefinition `json:"jobs"`
+	RedactPatterns []string                 `json:"redactPatterns"`
+
+	// SyncSecrets fields
+	Secrets map[string]string `json:"secrets"`
 
 	// Payload field (future proofing if we receive wrapped payloads)
 	// Currently not used for incoming messages but good practice
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+const (
+	defaultOutboxMaxBytes = 100 * 1024 * 1024 // 100MB
+	defaultOutboxMaxAge   = 7 * 24 * time.Hour
+	outboxFileSuffix      = ".json"
+	outboxStampWidth      = 20 // Digits of the zero-padded timestamp prefix
+)
+
+// executionIDPattern restricts execution IDs to characters that are safe to embed
+// in file names. IDs arriving over the local socket are untrusted input.
+var executionIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// newExecutionID returns a random identifier for an execution report.
+func newExecutionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand failing is practically impossible; fall back to the clock.
+		return fmt.Sprintf("t%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func validExecutionID(id string) bool {
+	return executionIDPattern.MatchString(id)
+}
+
+// outbox is a durable on-disk spool of execution reports awaiting server acknowledgement.
+// Each report is stored as a single JSON file whose name starts with a zero-padded
+// timestamp, so lexical order of the directory listing is delivery order.
+// An in-memory index of the files is built once when the outbox is opened, so
+// finding, capping and listing reports never touches the disk; a report is only
+// read when it is sent.
+type outbox struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	mu       sync.Mutex
+	index    []outboxFile // Stored reports in delivery order
+	total    int64        // Combined size of the indexed files
+}
+
+// outboxFile is the index entry of a stored report.
+type outboxFile struct {
+	name    string
+	id      string
+	size    int64
+	written time.Time
+}
+
+// outboxEntry is a report loaded from the outbox.
+type outboxEntry struct {
+	id     string
+	path   string
+	report protocol.ExecutionReportPayload
+}
+
+func newOutbox(dir string, maxBytes int64, maxAge time.Duration) (*outbox, error) {
+	// SECURITY: Reports contain job output; keep the spool private to the daemon user.
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultOutboxMaxBytes
+	}
+	if maxAge <= 0 {
+		maxAge = defaultOutboxMaxAge
+	}
+	o := &outbox{dir: dir, maxBytes: maxBytes, maxAge: maxAge}
+	if err := o.loadIndex(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	return o, nil
+}
+
+// loadIndex indexes the reports already on disk, e.g. from before a restart.
+func (o *outbox) loadIndex() error {
+	dirEntries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range dirEntries {
+		name := e.Name()
+		id := outboxEntryID(name)
+		if e.IsDir() || !strings.HasSuffix(name, outboxFileSuffix) || !validExecutionID(id) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		o.index = append(o.index, outboxFile{name: name, id: id, size: info.Size(), written: info.ModTime()})
+		o.total += info.Size()
+	}
+	sort.Slice(o.index, func(i, j int) bool { return o.index[i].name < o.index[j].name })
+	return nil
+}
+
+// put durably stores a report. The report must carry a valid execution ID.
+func (o *outbox) put(report protocol.ExecutionReportPayload) error {
+	if !validExecutionID(report.ExecutionID) {
+		return fmt.Errorf("invalid execution ID %q", report.ExecutionID)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// Reports are idempotent by execution ID; a resubmitted report is already queued.
+	if o.findLocked(report.ExecutionID) >= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("%0*d-%s%s", outboxStampWidth, now.UnixNano(), report.ExecutionID, outboxFileSuffix)
+	path := filepath.Join(o.dir, name)
+
+	// Write atomically so a crash never leaves a half-written report behind.
+	tmpFile := path + ".tmp"
+	f, err := os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	o.index = append(o.index, outboxFile{name: name, id: report.ExecutionID, size: int64(len(data)), written: now})
+	o.total += int64(len(data))
+	o.enforceLimitsLocked()
+	return nil
+}
+
+// remove deletes the report with the given execution ID, if present.
+func (o *outbox) remove(executionID string) error {
+	if !validExecutionID(executionID) {
+		return fmt.Errorf("invalid execution ID %q", executionID)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.findLocked(executionID)
+	if i < 0 {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(o.dir, o.index[i].name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	o.dropLocked(i)
+	return nil
+}
+
+// findLocked returns the index position of the report with the given
+// execution ID, or -1. IDs may contain '-', so IDs are compared exactly rather
+// than matched against file names: "*-b.json" would also match the report "a-b".
+func (o *outbox) findLocked(executionID string) int {
+	for i, f := range o.index {
+		if f.id == executionID {
+			return i
+		}
+	}
+	return -1
+}
+
+// dropLocked removes position i from the index.
+func (o *outbox) dropLocked(i int) {
+	o.total -= o.index[i].size
+	o.index = append(o.index[:i], o.index[i+1:]...)
+}
+
+// outboxEntryID returns the execution ID encoded in an outbox file name, or ""
+// if the name is not in the outbox format.
+func outboxEntryID(name string) string {
+	id := strings.TrimSuffix(name, outboxFileSuffix)
+	if len(id) <= outboxStampWidth+1 || id[outboxStampWidth] != '-' {
+		return ""
+	}
+	return id[outboxStampWidth+1:]
+}
+
+// ids returns the execution IDs of all stored reports, oldest first. Expired
+// reports are dropped.
+func (o *outbox) ids() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.enforceLimitsLocked()
+	ids := make([]string, len(o.index))
+	for i, f := range o.index {
+		ids[i] = f.id
+	}
+	return ids
+}
+
+// load reads one stored report. A corrupt report is discarded, since it
+// would otherwise block the queue forever.
+func (o *outbox) load(executionID string) (outboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	i := o.findLocked(executionID)
+	if i < 0 {
+		return outboxEntry{}, fmt.Errorf("report %q is not in the outbox", executionID)
+	}
+	name := o.index[i].name
+	path := filepath.Join(o.dir, name)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		o.dropLocked(i)
+		return outboxEntry{}, err
+	}
+	if err != nil {
+		return outboxEntry{}, err
+	}
+	var report protocol.ExecutionReportPayload
+	if err := json.Unmarshal(data, &report); err != nil {
+		log.Printf("Outbox: discarding corrupt entry %s: %v", name, err)
+		os.Remove(path)
+		o.dropLocked(i)
+		return outboxEntry{}, err
+	}
+	return outboxEntry{id: executionID, path: path, report: report}, nil
+}
+
+// pending returns all stored reports, oldest first. Expired reports are dropped.
+func (o *outbox) pending() ([]outboxEntry, error) {
+	var entries []outboxEntry
+	for _, id := range o.ids() {
+		e, err := o.load(id)
+		if err != nil {
+			log.Printf("Outbox: failed to read report %s: %v", id, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// enforceLimitsLocked drops the oldest reports until the outbox is within its age
+// and size caps, so an extended outage cannot fill the disk.
+func (o *outbox) enforceLimitsLocked() {
+	cutoff := time.Now().Add(-o.maxAge)
+	for i := 0; i < len(o.index); {
+		f := o.index[i]
+		switch {
+		case f.written.Before(cutoff):
+			log.Printf("Outbox: dropping expired report %s", f.name)
+		case o.total > o.maxBytes:
+			log.Printf("Outbox: size limit exceeded, dropping oldest report %s", f.name)
+		default:
+			i++
+			continue
+		}
+		os.Remove(filepath.Join(o.dir, f.name))
+		o.dropLocked(i)
+	}
+}
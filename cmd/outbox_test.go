@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestOutbox_PutPendingRemove(t *testing.T) {
+	ob, err := newOutbox(filepath.Join(t.TempDir(), "outbox"), 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+
+	ids := []string{"first", "second", "third"}
+	for _, id := range ids {
+		if err := ob.put(protocol.ExecutionReportPayload{ExecutionID: id, JobID: "job-" + id}); err != nil {
+			t.Fatalf("put(%s) failed: %v", id, err)
+		}
+	}
+
+	// Resubmitting a report with a known execution ID must not duplicate it.
+	if err := ob.put(protocol.ExecutionReportPayload{ExecutionID: "first", JobID: "job-first"}); err != nil {
+		t.Fatalf("put of duplicate failed: %v", err)
+	}
+
+	entries, err := ob.pending()
+	if err != nil {
+		t.Fatalf("pending failed: %v", err)
+	}
+	if len(entries) != len(ids) {
+		t.Fatalf("Expected %d entries, got %d", len(ids), len(entries))
+	}
+	for i, e := range entries {
+		if e.id != ids[i] || e.report.JobID != "job-"+ids[i] {
+			t.Errorf("Entry %d = %q/%q, want %q in insertion order", i, e.id, e.report.JobID, ids[i])
+		}
+	}
+
+	if err := ob.remove("second"); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	entries, _ = ob.pending()
+	if len(entries) != 2 || entries[0].id != "first" || entries[1].id != "third" {
+		t.Errorf("Unexpected entries after remove: %+v", entries)
+	}
+}
+
+func TestOutbox_RejectsUnsafeIDs(t *testing.T) {
+	ob, err := newOutbox(filepath.Join(t.TempDir(), "outbox"), 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+
+	for _, id := range []string{"", "../escape", "a/b", "*"} {
+		if err := ob.put(protocol.ExecutionReportPayload{ExecutionID: id}); err == nil {
+			t.Errorf("put accepted unsafe ID %q", id)
+		}
+		if err := ob.remove(id); err == nil {
+			t.Errorf("remove accepted unsafe ID %q", id)
+		}
+	}
+}
+
+func TestOutbox_EnforcesLimits(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	ob, err := newOutbox(dir, 1, time.Hour)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+
+	// With a 1 byte cap nothing fits, so every put drops all stored reports.
+	ob.put(protocol.ExecutionReportPayload{ExecutionID: "a"})
+	ob.put(protocol.ExecutionReportPayload{ExecutionID: "b"})
+	entries, _ := ob.pending()
+	if len(entries) != 0 {
+		t.Errorf("Expected size cap to drop all entries, got %d", len(entries))
+	}
+
+	// Age cap: backdate an entry beyond maxAge.
+	ob.maxBytes = defaultOutboxMaxBytes
+	ob.put(protocol.ExecutionReportPayload{ExecutionID: "old"})
+	ob.put(protocol.ExecutionReportPayload{ExecutionID: "new"})
+	matches, _ := filepath.Glob(filepath.Join(dir, "*-old.json"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected stored entry for 'old', got %v", matches)
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(matches[0], past, past)
+
+	// The index is built from disk when the outbox is opened, e.g. after a restart.
+	ob, err = newOutbox(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+	entries, _ = ob.pending()
+	if len(entries) != 1 || entries[0].id != "new" {
+		t.Errorf("Expected only 'new' to survive the age cap, got %+v", entries)
+	}
+}
+
+func TestOutbox_IDsWithDashes(t *testing.T) {
+	ob, err := newOutbox(filepath.Join(t.TempDir(), "outbox"), 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+
+	// "a-b" ends in "-b", so a suffix match for "b" would treat it as a duplicate.
+	for _, id := range []string{"a-b", "b"} {
+		if err := ob.put(protocol.ExecutionReportPayload{ExecutionID: id}); err != nil {
+			t.Fatalf("put(%s) failed: %v", id, err)
+		}
+	}
+	entries, _ := ob.pending()
+	if len(entries) != 2 || entries[0].id != "a-b" || entries[1].id != "b" {
+		t.Fatalf("Expected both reports to be stored, got %+v", entries)
+	}
+
+	if err := ob.remove("b"); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	entries, _ = ob.pending()
+	if len(entries) != 1 || entries[0].id != "a-b" {
+		t.Errorf("Expected only 'b' to be removed, got %+v", entries)
+	}
+}
+
+func TestOutbox_ReadsOnlyReportsBeingSent(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	ob, err := newOutbox(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+	for _, id := range []string{"first", "second"} {
+		ob.put(protocol.ExecutionReportPayload{ExecutionID: id, JobID: "job-" + id})
+	}
+
+	// Reading a corrupt report discards it, so it is only discarded if listing
+	// or loading another report reads it.
+	matches, _ := filepath.Glob(filepath.Join(dir, "*-first.json"))
+	if len(matches) != 1 {
+		t.Fatalf("Expected stored entry for 'first', got %v", matches)
+	}
+	os.WriteFile(matches[0], []byte("corrupt"), 0600)
+
+	if ids := ob.ids(); len(ids) != 2 || ids[0] != "first" || ids[1] != "second" {
+		t.Errorf("ids() = %v, want [first second]", ids)
+	}
+	e, err := ob.load("second")
+	if err != nil || e.report.JobID != "job-second" {
+		t.Errorf("load(second) = %+v, %v", e, err)
+	}
+	if _, err := os.Stat(matches[0]); err != nil {
+		t.Errorf("report 'first' was read without being sent: %v", err)
+	}
+
+	// Reopening rebuilds the same index from the directory.
+	reopened, err := newOutbox(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+	if ids := reopened.ids(); len(ids) != 2 || ids[0] != "first" || ids[1] != "second" {
+		t.Errorf("ids() after reopening = %v, want [first second]", ids)
+	}
+}
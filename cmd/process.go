@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const (
+	// killGracePeriod is how long a process group gets to exit after SIGTERM
+	// before it is sent SIGKILL.
+	killGracePeriod = 10 * time.Second
+	// groupPollInterval is how often terminate checks whether the group has exited.
+	groupPollInterval = 50 * time.Millisecond
+	// timeoutExitCode is the exit code reported for timed-out jobs, matching timeout(1).
+	timeoutExitCode = 124
+)
+
+// jobProcess is a command running in its own process group, so that the whole
+// process tree it spawns can be signalled at once.
+type jobProcess struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// startJobProcess starts cmd as the leader of a new process group.
+func startJobProcess(cmd *exec.Cmd) (*jobProcess, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &jobProcess{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// wait blocks until the process exits and returns the result of cmd.Wait.
+func (p *jobProcess) wait() error {
+	<-p.done
+	return p.err
+}
+
+// terminate sends SIGTERM to the process group, then SIGKILL if any member of
+// the group is still alive after the grace period. The leader exiting is not
+// enough: children that ignore SIGTERM would otherwise outlive the job. It
+// returns once the leader has exited.
+func (p *jobProcess) terminate(grace time.Duration) {
+	// The group ID equals the leader's PID; a negative PID addresses the group.
+	pgid := p.cmd.Process.Pid
+	syscall.Kill(-pgid, syscall.SIGTERM)
+
+	deadline := time.After(grace)
+	ticker := time.NewTicker(groupPollInterval)
+	defer ticker.Stop()
+	for processGroupAlive(pgid) {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			syscall.Kill(-pgid, syscall.SIGKILL)
+			<-p.done
+			return
+		}
+	}
+	<-p.done
+}
+
+// processGroupAlive reports whether any process in the group still exists.
+// The group ID cannot be reused while it does, so polling it is safe.
+func processGroupAlive(pgid int) bool {
+	return syscall.Kill(-pgid, 0) != syscall.ESRCH
+}
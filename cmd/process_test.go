@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestJobProcess_TerminateStopsGroup(t *testing.T) {
+	proc, err := startJobProcess(exec.Command("sh", "-c", "sleep 100 & sleep 100"))
+	if err != nil {
+		t.Fatalf("startJobProcess failed: %v", err)
+	}
+	pgid := proc.cmd.Process.Pid
+
+	proc.terminate(time.Second)
+	waitGroupGone(t, pgid)
+}
+
+func TestJobProcess_TerminateKillsChildrenIgnoringSIGTERM(t *testing.T) {
+	// The shell exits on SIGTERM at once, but its background child ignores it.
+	proc, err := startJobProcess(exec.Command("sh", "-c", `(trap "" TERM; sleep 100) & sleep 100`))
+	if err != nil {
+		t.Fatalf("startJobProcess failed: %v", err)
+	}
+	pgid := proc.cmd.Process.Pid
+	time.Sleep(200 * time.Millisecond) // Let the shell start its children
+
+	start := time.Now()
+	proc.terminate(time.Second)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("terminate returned after %v, before the grace period", elapsed)
+	}
+	waitGroupGone(t, pgid)
+}
+
+// waitGroupGone fails unless every process of the group exits shortly.
+func waitGroupGone(t *testing.T, pgid int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for syscall.Kill(-pgid, 0) != syscall.ESRCH {
+		if time.Now().After(deadline) {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+			t.Fatal("process group still alive after terminate")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
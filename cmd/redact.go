@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+const (
+	redactedPlaceholder = "[REDACTED]"
+	// minRedactLength avoids mangling output with trivially short secret values.
+	minRedactLength = 4
+)
+
+// builtinRedactPatterns match common credential formats in job output.
+// Go regexps are RE2 (linear time), so server-supplied patterns cannot cause
+// catastrophic backtracking either.
+var builtinRedactPatterns = []*regexp.Regexp{
+	// AWS access key IDs (long-term and temporary)
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+	// AWS secret access keys in key=value or key: value form
+	regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[=:]\s*["']?[A-Za-z0-9/+=]{40}`),
+	// HTTP bearer tokens
+	regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+	// GitHub tokens
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),
+	// PEM private key blocks
+	regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`),
+}
+
+// compileRedactPatterns compiles user- or server-supplied patterns, skipping
+// (and logging) invalid ones rather than rejecting the whole set. Patterns that
+// match the empty string, such as "a*", are invalid: they would insert the
+// replacement between every character of the output.
+func compileRedactPatterns(exprs []string) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Printf("Ignoring invalid redaction pattern %q: %v", expr, err)
+			continue
+		}
+		if re.MatchString("") {
+			log.Printf("Ignoring redaction pattern %q: it matches the empty string", expr)
+			continue
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns
+}
+
+// redactor replaces sensitive values in job output before it leaves the host.
+// It counts replacements so reports can say how much was redacted.
+// A redactor is not safe for concurrent use; create one per report.
+type redactor struct {
+	values   []string
+	patterns []*regexp.Regexp
+	count    int
+}
+
+// addValues registers literal values (e.g. injected secrets) to redact.
+func (r *redactor) addValues(values ...string) {
+	for _, v := range values {
+		if len(v) >= minRedactLength {
+			r.values = append(r.values, v)
+		}
+	}
+}
+
+// redact returns s with every registered value and pattern match replaced.
+func (r *redactor) redact(s string) string {
+	for _, v := range r.values {
+		if n := strings.Count(s, v); n > 0 {
+			s = strings.ReplaceAll(s, v, redactedPlaceholder)
+			r.count += n
+		}
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllStringFunc(s, func(string) string {
+			r.count++
+			return redactedPlaceholder
+		})
+	}
+	return s
+}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+)
+
+// chunkRedactor redacts a stream of output chunks. Multi-line private key
+// blocks may span chunks, so it remembers whether a block is still open and
+// redacts everything until the block ends.
+type chunkRedactor struct {
+	inPrivateKey bool
+}
+
+func (c *chunkRedactor) redact(data string, r *redactor) string {
+	if c.inPrivateKey {
+		loc := privateKeyEnd.FindStringIndex(data)
+		if loc == nil {
+			r.count++
+			return redactedPlaceholder
+		}
+		data = redactedPlaceholder + data[loc[1]:]
+		r.count++
+		c.inPrivateKey = false
+	}
+
+	// Complete blocks are handled by the built-in pattern; a remaining BEGIN
+	// marker starts a block that continues in a later chunk.
+	data = r.redact(data)
+	if loc := privateKeyBegin.FindStringIndex(data); loc != nil {
+		data = data[:loc[0]] + redactedPlaceholder
+		r.count++
+		c.inPrivateKey = true
+	}
+	return data
+}
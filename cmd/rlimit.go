@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+	"github.com/spf13/cobra"
+)
+
+// cpuLimitGrace is how far the hard CPU limit is set above the soft limit, so
+// the job receives SIGXCPU (and the report says why) before the kernel's SIGKILL.
+const cpuLimitGrace = 5
+
+// maxLimitValue bounds any limit value in the job's units (MB, seconds or count).
+const maxLimitValue = 1 << 40
+
+// limitKind maps one field of protocol.ResourceLimits to its rlimit.
+type limitKind struct {
+	key      string // Key in the --limits flag
+	field    string // JSON field name, used in errors and reports
+	resource int    // RLIMIT_* constant, or -1 if unsupported on this platform
+	unit     uint64 // Bytes per unit for size limits, 1 otherwise
+	min      int64
+	value    func(*protocol.ResourceLimits) *int64
+}
+
+var limitKinds = []limitKind{
+	{"as", "addressSpaceMb", rlimitAS, 1 << 20, 1, func(l *protocol.ResourceLimits) *int64 { return l.AddressSpaceMB }},
+	{"nofile", "openFiles", syscall.RLIMIT_NOFILE, 1, 1, func(l *protocol.ResourceLimits) *int64 { return l.OpenFiles }},
+	{"cpu", "cpuSeconds", syscall.RLIMIT_CPU, 1, 1, func(l *protocol.ResourceLimits) *int64 { return l.CPUSeconds }},
+	{"nproc", "processes", rlimitNproc, 1, 1, func(l *protocol.ResourceLimits) *int64 { return l.Processes }},
+	{"core", "coreSizeMb", syscall.RLIMIT_CORE, 1 << 20, 0, func(l *protocol.ResourceLimits) *int64 { return l.CoreSizeMB }},
+	{"fsize", "fileSizeMb", syscall.RLIMIT_FSIZE, 1 << 20, 1, func(l *protocol.ResourceLimits) *int64 { return l.FileSizeMB }},
+}
+
+// rlimit is one limit to apply, in the kernel's units.
+type rlimit struct {
+	kind  limitKind
+	value uint64
+}
+
+// validateLimits checks a job's limits against the ranges and the platform.
+func validateLimits(limits *protocol.ResourceLimits) error {
+	if limits == nil {
+		return nil
+	}
+	for _, k := range limitKinds {
+		v := k.value(limits)
+		if v == nil {
+			continue
+		}
+		if *v < k.min || *v > maxLimitValue {
+			return fmt.Errorf("limits.%s must be between %d and %d", k.field, k.min, int64(maxLimitValue))
+		}
+		if k.resource < 0 {
+			return fmt.Errorf("limits.%s is not supported on this platform", k.field)
+		}
+	}
+	return nil
+}
+
+// formatLimits encodes a job's limits for the exec --limits flag, e.g.
+// "nofile=1024,cpu=60". It returns "" if no limit is set.
+func formatLimits(limits *protocol.ResourceLimits) string {
+	if limits == nil {
+		return ""
+	}
+	var parts []string
+	for _, k := range limitKinds {
+		if v := k.value(limits); v != nil {
+			parts = append(parts, k.key+"="+strconv.FormatInt(*v, 10))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseLimits decodes a --limits flag value.
+func parseLimits(s string) ([]rlimit, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var limits []rlimit
+	for _, part := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		var kind *limitKind
+		for i := range limitKinds {
+			if limitKinds[i].key == key {
+				kind = &limitKinds[i]
+			}
+		}
+		if kind == nil {
+			return nil, fmt.Errorf("unknown limit %q", key)
+		}
+		if kind.resource < 0 {
+			return nil, fmt.Errorf("limit %q is not supported on this platform", key)
+		}
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || v < kind.min || v > maxLimitValue {
+			return nil, fmt.Errorf("invalid value %q for limit %q", value, key)
+		}
+		limits = append(limits, rlimit{kind: *kind, value: uint64(v) * kind.unit})
+	}
+	return limits, nil
+}
+
+// applyLimits sets limits on the current process. Soft and hard limits are
+// both lowered so the job cannot raise them again. A limit above the inherited
+// hard limit is an error, since only root could raise it.
+func applyLimits(limits []rlimit) error {
+	for _, l := range limits {
+		var old syscall.Rlimit
+		if err := syscall.Getrlimit(l.kind.resource, &old); err != nil {
+			return fmt.Errorf("failed to read %s limit: %w", l.kind.field, err)
+		}
+		cur, max := l.value, l.value
+		if l.kind.key == "cpu" {
+			max += cpuLimitGrace
+		}
+		if oldMax := uint64(old.Max); max > oldMax && os.Geteuid() != 0 {
+			if cur > oldMax {
+				return fmt.Errorf("%s limit %d exceeds the hard limit %d", l.kind.field, cur/l.kind.unit, oldMax/l.kind.unit)
+			}
+			max = oldMax
+		}
+		var lim syscall.Rlimit
+		setRlimitValue(&lim.Cur, cur)
+		setRlimitValue(&lim.Max, max)
+		if err := syscall.Setrlimit(l.kind.resource, &lim); err != nil {
+			return fmt.Errorf("failed to set %s limit: %w", l.kind.field, err)
+		}
+	}
+	return nil
+}
+
+// setRlimitValue stores v in an Rlimit field, whose type differs by platform.
+func setRlimitValue[T int64 | uint64](field *T, v uint64) {
+	*field = T(v)
+}
+
+// limitExceeded names the limit that terminated a job, or returns "" if the
+// job did not die from one. Only the signal the job itself died from counts:
+// an exit code of 128+n may come from a shell reporting a child, or from the
+// job's own exit status, and says nothing reliable about a limit.
+func limitExceeded(limits []rlimit, outcome processOutcome) string {
+	sig := outcome.signal
+	for _, l := range limits {
+		switch {
+		case l.kind.key == "cpu" && sig == syscall.SIGXCPU,
+			l.kind.key == "fsize" && sig == syscall.SIGXFSZ:
+			return l.kind.field
+		case l.kind.key == "cpu" && sig == syscall.SIGKILL && outcome.usage != nil:
+			// The kernel kills jobs that ignore SIGXCPU at the hard limit.
+			used := (outcome.usage.UserCPUMs + outcome.usage.SystemCPUMs) / 1000
+			if uint64(used) >= l.value {
+				return l.kind.field
+			}
+		}
+	}
+	return ""
+}
+
+// limitsCmd applies resource limits to itself and then replaces itself with
+// the job's command. exec mode starts jobs with limits through it, because Go
+// cannot run code in the child between fork and exec.
+var limitsCmd = &cobra.Command{
+	Use:    "apply-limits --limits LIMITS -- command [args...]",
+	Short:  "Apply resource limits and execute a command",
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(1),
+	Run:    runApplyLimits,
+}
+
+var limitsSpec string
+
+func init() {
+	rootCmd.AddCommand(limitsCmd)
+	limitsCmd.Flags().StringVar(&limitsSpec, "limits", "", "Comma-separated limits, e.g. nofile=1024,cpu=60")
+}
+
+func runApplyLimits(cmd *cobra.Command, args []string) {
+	limits, err := parseLimits(limitsSpec)
+	if err == nil {
+		err = applyLimits(limits)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cc-agent: %v\n", err)
+		os.Exit(126)
+	}
+
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cc-agent: %v\n", err)
+		os.Exit(127)
+	}
+	err = syscall.Exec(path, args, os.Environ())
+	fmt.Fprintf(os.Stderr, "cc-agent: failed to execute %s: %v\n", args[0], err)
+	os.Exit(126)
+}
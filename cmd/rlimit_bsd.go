@@ -0,0 +1,12 @@
+//go:build darwin || freebsd || netbsd || dragonfly
+// +build darwin freebsd netbsd dragonfly
+
+package cmd
+
+import "syscall"
+
+// Resource numbers that are not available on every platform.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = 0x7 // RLIMIT_NPROC, missing from package syscall
+)
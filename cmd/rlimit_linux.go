@@ -0,0 +1,12 @@
+//go:build linux
+// +build linux
+
+package cmd
+
+import "syscall"
+
+// Resource numbers that are not available on every platform.
+const (
+	rlimitAS    = syscall.RLIMIT_AS
+	rlimitNproc = 0x6 // RLIMIT_NPROC, missing from package syscall
+)
@@ -0,0 +1,11 @@
+//go:build openbsd
+// +build openbsd
+
+package cmd
+
+// Resource numbers that are not available on every platform. OpenBSD has no
+// address space limit.
+const (
+	rlimitAS    = -1
+	rlimitNproc = 0x7 // RLIMIT_NPROC, missing from package syscall
+)
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !dragonfly && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!dragonfly,!openbsd
+
+package cmd
+
+// Address space and process limits are not supported on this platform.
+const (
+	rlimitAS    = -1
+	rlimitNproc = -1
+)
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func int64p(v int64) *int64 { return &v }
+
+func TestFormatAndParseLimits(t *testing.T) {
+	limits := &protocol.ResourceLimits{
+		OpenFiles:  int64p(1024),
+		CPUSeconds: int64p(60),
+		CoreSizeMB: int64p(0),
+		FileSizeMB: int64p(100),
+	}
+	s := formatLimits(limits)
+	if s != "nofile=1024,cpu=60,core=0,fsize=100" {
+		t.Fatalf("formatLimits() = %q", s)
+	}
+
+	parsed, err := parseLimits(s)
+	if err != nil {
+		t.Fatalf("parseLimits(%q) failed: %v", s, err)
+	}
+	want := map[string]uint64{"nofile": 1024, "cpu": 60, "core": 0, "fsize": 100 << 20}
+	if len(parsed) != len(want) {
+		t.Fatalf("parseLimits() = %v", parsed)
+	}
+	for _, l := range parsed {
+		if l.value != want[l.kind.key] {
+			t.Errorf("%s = %d, want %d", l.kind.key, l.value, want[l.kind.key])
+		}
+	}
+
+	if formatLimits(nil) != "" || formatLimits(&protocol.ResourceLimits{}) != "" {
+		t.Error("formatLimits() without limits should be empty")
+	}
+	for _, bad := range []string{"mem=1", "cpu=", "cpu=-1", "nofile=0", "cpu=1;rm"} {
+		if _, err := parseLimits(bad); err == nil {
+			t.Errorf("parseLimits(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	if err := validateLimits(&protocol.ResourceLimits{CoreSizeMB: int64p(0), Processes: int64p(50)}); err != nil {
+		t.Errorf("valid limits rejected: %v", err)
+	}
+	for _, limits := range []*protocol.ResourceLimits{
+		{OpenFiles: int64p(0)},
+		{CPUSeconds: int64p(-5)},
+		{AddressSpaceMB: int64p(maxLimitValue + 1)},
+	} {
+		if err := validateLimits(limits); err == nil {
+			t.Errorf("validateLimits(%q) succeeded, want error", formatLimits(limits))
+		}
+	}
+}
+
+func TestLimitExceeded(t *testing.T) {
+	limits, _ := parseLimits("cpu=2,fsize=1")
+	tests := []struct {
+		name    string
+		outcome processOutcome
+		want    string
+	}{
+		{"SIGXCPU", processOutcome{signal: syscall.SIGXCPU}, "cpuSeconds"},
+		{"SIGXFSZ", processOutcome{signal: syscall.SIGXFSZ}, "fileSizeMb"},
+		{"killed at hard CPU limit", processOutcome{signal: syscall.SIGKILL, usage: &protocol.ResourceUsage{UserCPUMs: 6900}}, "cpuSeconds"},
+		{"killed otherwise", processOutcome{signal: syscall.SIGKILL, usage: &protocol.ResourceUsage{UserCPUMs: 100}}, ""},
+		// Exit code 128+SIGXFSZ without a signal, e.g. from a shell or the job itself.
+		{"exit code only", processOutcome{}, ""},
+	}
+	for _, tt := range tests {
+		if got := limitExceeded(limits, tt.outcome); got != tt.want {
+			t.Errorf("%s: limitExceeded() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// Without a configured limit, the same signal is not attributed to one.
+	if got := limitExceeded(nil, processOutcome{signal: syscall.SIGXCPU}); got != "" {
+		t.Errorf("limitExceeded() without limits = %q", got)
+	}
+}
+
+func TestExecArgs_Limits(t *testing.T) {
+	job := protocol.JobDefinition{JobID: "j", Command: "true", Limits: &protocol.ResourceLimits{OpenFiles: int64p(256)}}
+	if args := strings.Join(execArgs(job), " "); !strings.Contains(args, " --limits nofile=256 ") {
+		t.Errorf("execArgs() = %q, want --limits", args)
+	}
+}
+
+func TestApplyLimitsHelper(t *testing.T) {
+	if os.Getenv("CC_APPLY_LIMITS_HELPER") == "" {
+		t.Skip("only run as a helper process")
+	}
+	limitsSpec = os.Getenv("CC_APPLY_LIMITS_HELPER")
+	runApplyLimits(nil, flag.Args())
+}
+
+func TestApplyLimits_Command(t *testing.T) {
+	// Run the test binary as "apply-limits --limits nofile=64 -- sh -c 'ulimit -n'".
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApplyLimitsHelper$", "--", "sh", "-c", "ulimit -n")
+	cmd.Env = append(os.Environ(), "CC_APPLY_LIMITS_HELPER=nofile=64")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("apply-limits failed: %v: %s", err, out)
+	}
+	if got := strings.TrimSpace(string(out)); got != "64" {
+		t.Errorf("ulimit -n = %q, want 64", got)
+	}
+}
+
+func TestValidateJob_ProcessLimitForRoot(t *testing.T) {
+	job := protocol.JobDefinition{JobID: "j", CronExpression: "* * * * *", Command: "true",
+		Limits: &protocol.ResourceLimits{Processes: int64p(10)}}
+
+	root := &daemon{executionMode: "user", isRoot: true}
+	if err := root.validateJob(&job); err == nil || !strings.Contains(err.Error(), "processes") {
+		t.Errorf("validateJob() as root = %v, want processes limit rejected", err)
+	}
+	nonRoot := &daemon{executionMode: "user"}
+	if err := nonRoot.validateJob(&job); err != nil {
+		t.Errorf("validateJob() as non-root = %v, want nil", err)
+	}
+}
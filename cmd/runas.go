@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// validateRunAs checks a job's target user and group against the local passwd
+// and group databases and the configured allowlist. An empty user keeps the
+// default (root in system mode, the daemon user in user mode).
+func validateRunAs(runAs, runAsGroup string, systemMode bool, allowed []string) error {
+	if runAs == "" {
+		if runAsGroup != "" {
+			return fmt.Errorf("runAsGroup requires runAs")
+		}
+		return nil
+	}
+
+	if !systemMode {
+		// Without root we cannot switch users; only the daemon's own user is possible.
+		if current, err := user.Current(); err == nil && current.Username == runAs && runAsGroup == "" {
+			return nil
+		}
+		return fmt.Errorf("runAs %q requires system mode", runAs)
+	}
+
+	// SECURITY: Only users explicitly allowed in the local config may be targeted,
+	// so a compromised control plane cannot run jobs as arbitrary accounts.
+	if runAs != "root" && !containsString(allowed, runAs) {
+		return fmt.Errorf("runAs user %q is not in allowed_run_as_users", runAs)
+	}
+
+	u, err := user.Lookup(runAs)
+	if err != nil {
+		return fmt.Errorf("unknown runAs user %q: %v", runAs, err)
+	}
+	if runAsGroup != "" {
+		if _, err := memberGroup(u, runAsGroup); err != nil {
+			return fmt.Errorf("invalid runAsGroup: %v", err)
+		}
+	}
+	return nil
+}
+
+// memberGroup looks up a group that u belongs to, as its primary group or a
+// supplementary one.
+// SECURITY: The group's GID becomes the job's primary GID, so it is limited to
+// groups the allowlisted user already has; otherwise runAsGroup would grant
+// access to any group on the host.
+func memberGroup(u *user.User, name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown group %q: %w", name, err)
+	}
+	if g.Gid == u.Gid {
+		return g, nil
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups of %q: %w", u.Username, err)
+	}
+	if !containsString(ids, g.Gid) {
+		return nil, fmt.Errorf("user %q is not a member of group %q", u.Username, name)
+	}
+	return g, nil
+}
+
+// lookupCredential resolves the credentials a job should run with: the user's
+// UID, primary GID (or the given group, which the user must belong to), and
+// supplementary groups.
+func lookupCredential(runAs, runAsGroup string) (*syscall.Credential, *user.User, error) {
+	u, err := user.Lookup(runAs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown user %q: %w", runAs, err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid UID for %q: %w", runAs, err)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid GID for %q: %w", runAs, err)
+	}
+	if runAsGroup != "" {
+		g, err := memberGroup(u, runAsGroup)
+		if err != nil {
+			return nil, nil, err
+		}
+		gid, err = strconv.ParseUint(g.Gid, 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid GID for group %q: %w", runAsGroup, err)
+		}
+	}
+
+	var groups []uint32
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				groups = append(groups, uint32(g))
+			}
+		}
+	}
+
+	if uid != uint64(os.Geteuid()) && os.Geteuid() != 0 {
+		return nil, nil, fmt.Errorf("running as %q requires root", runAs)
+	}
+
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups}, u, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// foreignGroup returns a group that u is not a member of, or skips the test.
+func foreignGroup(t *testing.T, u *user.User) string {
+	t.Helper()
+	ids, _ := u.GroupIds()
+	for _, name := range []string{"nogroup", "nobody", "daemon", "bin"} {
+		g, err := user.LookupGroup(name)
+		if err == nil && g.Gid != u.Gid && !containsString(ids, g.Gid) {
+			return name
+		}
+	}
+	t.Skip("no group found that the current user is not a member of")
+	return ""
+}
+
+func primaryGroup(t *testing.T, u *user.User) string {
+	t.Helper()
+	g, err := user.LookupGroupId(u.Gid)
+	if err != nil {
+		t.Skipf("primary group of %q not found: %v", u.Username, err)
+	}
+	return g.Name
+}
+
+func TestValidateRunAs(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user unknown: %v", err)
+	}
+	primary := primaryGroup(t, current)
+	foreign := foreignGroup(t, current)
+	allowed := []string{current.Username}
+
+	tests := []struct {
+		name       string
+		runAs      string
+		runAsGroup string
+		systemMode bool
+		allowed    []string
+		wantErr    string
+	}{
+		{name: "default user", systemMode: true},
+		{name: "group without user", runAsGroup: primary, systemMode: true, wantErr: "requires runAs"},
+		{name: "allowed user", runAs: current.Username, systemMode: true, allowed: allowed},
+		{name: "user not allowed", runAs: "cc-no-such-user", systemMode: true, wantErr: "allowed_run_as_users"},
+		{name: "unknown user", runAs: "cc-no-such-user", systemMode: true, allowed: []string{"cc-no-such-user"}, wantErr: "unknown runAs user"},
+		{name: "primary group", runAs: current.Username, runAsGroup: primary, systemMode: true, allowed: allowed},
+		{name: "foreign group", runAs: current.Username, runAsGroup: foreign, systemMode: true, allowed: allowed, wantErr: "not a member"},
+		{name: "unknown group", runAs: current.Username, runAsGroup: "cc-no-such-group", systemMode: true, allowed: allowed, wantErr: "unknown group"},
+		{name: "user mode self", runAs: current.Username},
+		{name: "user mode group", runAs: current.Username, runAsGroup: primary, wantErr: "requires system mode"},
+		{name: "user mode other user", runAs: "cc-no-such-user", wantErr: "requires system mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRunAs(tt.runAs, tt.runAsGroup, tt.systemMode, tt.allowed)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateRunAs() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateRunAs() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupCredential(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user unknown: %v", err)
+	}
+
+	cred, u, err := lookupCredential(current.Username, "")
+	if err != nil {
+		t.Fatalf("lookupCredential() error = %v", err)
+	}
+	if u.Uid != current.Uid {
+		t.Errorf("user UID = %s, want %s", u.Uid, current.Uid)
+	}
+	if got := cred.Uid; strconv.FormatUint(uint64(got), 10) != current.Uid {
+		t.Errorf("Uid = %d, want %s", got, current.Uid)
+	}
+	if got := cred.Gid; strconv.FormatUint(uint64(got), 10) != current.Gid {
+		t.Errorf("Gid = %d, want primary group %s", got, current.Gid)
+	}
+
+	cred, _, err = lookupCredential(current.Username, primaryGroup(t, current))
+	if err != nil || strconv.FormatUint(uint64(cred.Gid), 10) != current.Gid {
+		t.Errorf("lookupCredential(primary group) = %v, %v", cred, err)
+	}
+
+	if _, _, err := lookupCredential(current.Username, foreignGroup(t, current)); err == nil {
+		t.Error("lookupCredential() accepted a group the user is not a member of")
+	}
+	if _, _, err := lookupCredential("cc-no-such-user", ""); err == nil {
+		t.Error("lookupCredential() accepted an unknown user")
+	}
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// runJob starts a synced job immediately through exec mode, outside its
+// schedule, and acknowledges the request to the server.
+func (d *daemon) runJob(jobID, executionID string) {
+	ack := protocol.RunJobAckMessage{Type: "run_job_ack", JobID: jobID}
+
+	if err := d.startManualRun(jobID, executionID, &ack); err != nil {
+		log.Printf("Rejected run_job for job %s: %v", jobID, err)
+		ack.Status = "rejected"
+		ack.Reason = err.Error()
+	} else {
+		log.Printf("Started manual run of job %s (execution %s)", jobID, ack.ExecutionID)
+		ack.Status = "started"
+	}
+
+	if err := d.sendMessage(ack); err != nil {
+		log.Printf("Failed to send run_job_ack: %v", err)
+	}
+}
+
+func (d *daemon) startManualRun(jobID, executionID string, ack *protocol.RunJobAckMessage) error {
+	job, ok := d.lookupJob(jobID)
+	if !ok {
+		return fmt.Errorf("unknown job %q", jobID)
+	}
+
+	if executionID == "" {
+		executionID = newExecutionID()
+	} else if !validExecutionID(executionID) {
+		return fmt.Errorf("invalid execution ID")
+	}
+	ack.ExecutionID = executionID
+
+	// Manual runs use the same exec arguments as the scheduled entry, so they
+	// start the same way and report the same way. The daemon already runs with
+	// the identity cron would use.
+	if _, err := spawnExec(execArgs(job, "--trigger", triggerManual, "--execution-id", executionID)); err != nil {
+		return fmt.Errorf("failed to start job: %w", err)
+	}
+	return nil
+}
+
+// spawnExec starts cc-agent with args (an exec mode invocation) in the
+// background. The wrapper reports its result via the socket like a cron run.
+// The returned channel is closed when the wrapper exits.
+func spawnExec(args []string) (<-chan struct{}, error) {
+	cmd := exec.Command(agentExecutable(), args...)
+	// Detach from the daemon so restarting it does not kill running jobs.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Reap the wrapper when it exits.
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	return done, nil
+}
+
+// syncedJobsFile records the installed jobs in the state directory, so run_job
+// works after a restart before the server syncs again. Jobs may carry
+// environment values, so the file is private like the job specs.
+const (
+	syncedJobsFile    = "jobs.json"
+	maxSyncedJobsSize = 16 * 1024 * 1024
+)
+
+// setJobs records the jobs of a successful install for run_job.
+func (d *daemon) setJobs(jobs []protocol.JobDefinition) {
+	d.jobsMu.Lock()
+	d.jobs = jobs
+	d.jobsMu.Unlock()
+
+	if err := saveSyncedJobs(stateDir, jobs); err != nil {
+		log.Printf("Failed to save installed jobs: %v", err)
+	}
+}
+
+func saveSyncedJobs(baseDir string, jobs []protocol.JobDefinition) error {
+	data, err := json.Marshal(jobs)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(baseDir, syncedJobsFile)
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
+
+// loadSyncedJobs returns the jobs saved by the last successful install, or
+// none if there was none.
+func loadSyncedJobs(baseDir string) ([]protocol.JobDefinition, error) {
+	data, err := readPrivateFile(filepath.Join(baseDir, syncedJobsFile), maxSyncedJobsSize)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var jobs []protocol.JobDefinition
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", syncedJobsFile, err)
+	}
+	return jobs, nil
+}
+
+// lookupJob returns the job with the given ID from the latest sync.
+func (d *daemon) lookupJob(jobID string) (protocol.JobDefinition, bool) {
+	d.jobsMu.Lock()
+	defer d.jobsMu.Unlock()
+	for _, job := range d.jobs {
+		if job.JobID == jobID {
+			return job, true
+		}
+	}
+	return protocol.JobDefinition{}, false
+}
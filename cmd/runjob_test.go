@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// fakeAgent replaces the agent binary started for runs with a script that
+// writes its arguments, one per line, to the returned file.
+func fakeAgent(t *testing.T) string {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "cc-agent")
+	content := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"" + argsFile + ".tmp\"\nmv \"" + argsFile + ".tmp\" \"" + argsFile + "\"\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := agentExecutable
+	agentExecutable = func() string { return script }
+	t.Cleanup(func() { agentExecutable = old })
+	return argsFile
+}
+
+// nextRunJobAck returns the next run_job_ack sent to the test server.
+func nextRunJobAck(t *testing.T, received <-chan []byte) protocol.RunJobAckMessage {
+	t.Helper()
+	var ack protocol.RunJobAckMessage
+	if err := json.Unmarshal(nextMessage(t, received), &ack); err != nil || ack.Type != "run_job_ack" {
+		t.Fatalf("message = %+v (%v), want run_job_ack", ack, err)
+	}
+	return ack
+}
+
+func TestRunJob_Rejected(t *testing.T) {
+	fakeAgent(t)
+	d := &daemon{jobs: []protocol.JobDefinition{{JobID: "known", CronExpression: "* * * * *", Command: "true"}}}
+	received := connectTestServer(t, d)
+
+	tests := []struct {
+		name, jobID, executionID, reason string
+	}{
+		{"unknown job", "missing", "", `unknown job "missing"`},
+		{"invalid execution ID", "known", "../escape", "invalid execution ID"},
+	}
+	for _, tt := range tests {
+		d.runJob(tt.jobID, tt.executionID)
+		ack := nextRunJobAck(t, received)
+		if ack.Status != "rejected" || ack.JobID != tt.jobID || ack.Reason != tt.reason {
+			t.Errorf("%s: ack = %+v, want rejected with %q", tt.name, ack, tt.reason)
+		}
+	}
+}
+
+func TestRunJob_SpawnsExecMode(t *testing.T) {
+	argsFile := fakeAgent(t)
+	d := &daemon{jobs: []protocol.JobDefinition{{
+		JobID:             "backup",
+		CronExpression:    "0 2 * * *",
+		Command:           "echo hi",
+		TimeoutSeconds:    30,
+		Retries:           2,
+		RetryDelaySeconds: 5,
+		Limits:            &protocol.ResourceLimits{OpenFiles: int64p(64)},
+	}}}
+	received := connectTestServer(t, d)
+
+	d.runJob("backup", "exec-42")
+	if ack := nextRunJobAck(t, received); ack.Status != "started" || ack.ExecutionID != "exec-42" {
+		t.Fatalf("ack = %+v, want started with the requested execution ID", ack)
+	}
+
+	var data []byte
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, _ = os.ReadFile(argsFile); data != nil {
+			break
+		}
+	}
+	args := " " + strings.ReplaceAll(string(data), "\n", " ")
+	for _, want := range []string{
+		" exec --job-id backup ",
+		" --trigger manual ",
+		" --execution-id exec-42 ",
+		" --timeout 30 ",
+		" --limits nofile=64 ",
+		" --retries 2 --retry-delay 5 ",
+		" -- /bin/sh -c echo hi ",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("exec mode started with %q, missing %q", args, want)
+		}
+	}
+}
+
+func TestInstallJobs_RunnableAfterRestart(t *testing.T) {
+	defer func(old string) { stateDir = old }(stateDir)
+	stateDir = t.TempDir()
+	fakeCrontab(t)
+
+	d := &daemon{executionMode: "user"}
+	jobs := []protocol.JobDefinition{{JobID: "j", CronExpression: "* * * * *", Command: "true"}}
+	if _, _, err := d.installJobs(jobs); err != nil {
+		t.Fatalf("installJobs failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(stateDir, syncedJobsFile))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("installed jobs not saved privately: %v, %v", info, err)
+	}
+	restored, err := loadSyncedJobs(stateDir)
+	if err != nil {
+		t.Fatalf("loadSyncedJobs failed: %v", err)
+	}
+	restarted := &daemon{jobs: restored}
+	if _, ok := restarted.lookupJob("j"); !ok {
+		t.Errorf("job not runnable after restart, restored %+v", restored)
+	}
+}
+
+func TestInstallJobs_FailureKeepsPreviousJobs(t *testing.T) {
+	defer func(old string) { stateDir = old }(stateDir)
+	stateDir = t.TempDir()
+	fakeCrontab(t)
+
+	d := &daemon{executionMode: "user"}
+	if _, _, err := d.installJobs([]protocol.JobDefinition{{JobID: "old", CronExpression: "* * * * *", Command: "true"}}); err != nil {
+		t.Fatalf("installJobs failed: %v", err)
+	}
+
+	// Make "crontab -" fail from now on.
+	dir := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1\" = - ] && { echo 'crontab: bad minute' >&2; exit 1; }\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "crontab"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if _, _, err := d.installJobs([]protocol.JobDefinition{{JobID: "new", CronExpression: "* * * * *", Command: "true"}}); err == nil {
+		t.Fatal("installJobs succeeded, want crontab failure")
+	}
+	if _, ok := d.lookupJob("new"); ok {
+		t.Error("job from a failed install can be run on demand")
+	}
+	if _, ok := d.lookupJob("old"); !ok {
+		t.Error("previously installed job is no longer runnable")
+	}
+}
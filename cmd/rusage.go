@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// signalNames maps the signals a job is likely to die from to their conventional names.
+// syscall.Signal.String() returns descriptions ("killed"), not names.
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:  "SIGHUP",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGQUIT: "SIGQUIT",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGTRAP: "SIGTRAP",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGUSR1: "SIGUSR1",
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGUSR2: "SIGUSR2",
+	syscall.SIGPIPE: "SIGPIPE",
+	syscall.SIGALRM: "SIGALRM",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGXCPU: "SIGXCPU",
+	syscall.SIGXFSZ: "SIGXFSZ",
+	syscall.SIGSYS:  "SIGSYS",
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("SIG%d", int(sig))
+}
+
+// processOutcome describes how a finished process terminated.
+type processOutcome struct {
+	signal     syscall.Signal // 0 if the process exited normally
+	coreDumped bool
+	usage      *protocol.ResourceUsage
+}
+
+// inspectProcessState extracts the terminating signal and resource usage of a
+// finished process. It tolerates a nil state (the process never started).
+func inspectProcessState(state *os.ProcessState) processOutcome {
+	var out processOutcome
+	if state == nil {
+		return out
+	}
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		out.signal = ws.Signal()
+		out.coreDumped = ws.CoreDump()
+	}
+
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok && ru != nil {
+		maxRSS := int64(ru.Maxrss)
+		// getrusage(2) reports ru_maxrss in bytes on macOS and in kilobytes elsewhere.
+		if runtime.GOOS == "darwin" {
+			maxRSS /= 1024
+		}
+		out.usage = &protocol.ResourceUsage{
+			UserCPUMs:              ru.Utime.Nano() / 1e6,
+			SystemCPUMs:            ru.Stime.Nano() / 1e6,
+			MaxRSSKB:               maxRSS,
+			InBlock:                int64(ru.Inblock),
+			OutBlock:               int64(ru.Oublock),
+			VoluntaryCtxSwitches:   int64(ru.Nvcsw),
+			InvoluntaryCtxSwitches: int64(ru.Nivcsw),
+		}
+	}
+	return out
+}
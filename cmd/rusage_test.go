@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestSignalName(t *testing.T) {
+	tests := []struct {
+		sig  syscall.Signal
+		want string
+	}{
+		{syscall.SIGTERM, "SIGTERM"},
+		{syscall.SIGKILL, "SIGKILL"},
+		{syscall.SIGSEGV, "SIGSEGV"},
+		{syscall.SIGXCPU, "SIGXCPU"},
+		{syscall.Signal(63), "SIG63"},
+	}
+	for _, tt := range tests {
+		if got := signalName(tt.sig); got != tt.want {
+			t.Errorf("signalName(%d) = %q, want %q", int(tt.sig), got, tt.want)
+		}
+	}
+}
+
+func runForOutcome(t *testing.T, script string) processOutcome {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = t.TempDir()
+	cmd.Run()
+	return inspectProcessState(cmd.ProcessState)
+}
+
+func TestInspectProcessState_Signal(t *testing.T) {
+	out := runForOutcome(t, "kill -TERM $$")
+	if out.signal != syscall.SIGTERM || signalName(out.signal) != "SIGTERM" {
+		t.Errorf("signal = %v, want SIGTERM", out.signal)
+	}
+	if out.coreDumped {
+		t.Errorf("coreDumped = true for SIGTERM")
+	}
+
+	if out := runForOutcome(t, "exit 3"); out.signal != 0 || out.coreDumped {
+		t.Errorf("normal exit reported signal %v, coreDumped %v", out.signal, out.coreDumped)
+	}
+}
+
+func TestInspectProcessState_CoreDump(t *testing.T) {
+	out := runForOutcome(t, "ulimit -c unlimited 2>/dev/null; kill -QUIT $$")
+	if out.signal != syscall.SIGQUIT {
+		t.Fatalf("signal = %v, want SIGQUIT", out.signal)
+	}
+	if !out.coreDumped {
+		// Whether a core is written depends on the host's core_pattern and limits.
+		t.Skip("host did not write a core dump")
+	}
+
+	if out := runForOutcome(t, "ulimit -c 0; kill -QUIT $$"); out.coreDumped {
+		t.Errorf("coreDumped = true with a zero core size limit")
+	}
+}
+
+func TestInspectProcessState_Rusage(t *testing.T) {
+	out := runForOutcome(t, "i=0; while [ $i -lt 200000 ]; do i=$((i+1)); done")
+	if out.usage == nil {
+		t.Fatal("usage = nil")
+	}
+	if out.usage.UserCPUMs+out.usage.SystemCPUMs <= 0 {
+		t.Errorf("CPU time = %d+%dms, want > 0", out.usage.UserCPUMs, out.usage.SystemCPUMs)
+	}
+	if out.usage.MaxRSSKB <= 0 {
+		t.Errorf("MaxRSSKB = %d, want > 0", out.usage.MaxRSSKB)
+	}
+}
+
+func TestInspectProcessState_NotStarted(t *testing.T) {
+	if out := inspectProcessState(nil); out.signal != 0 || out.coreDumped || out.usage != nil {
+		t.Errorf("inspectProcessState(nil) = %+v, want zero", out)
+	}
+}
+
+func TestAttempt_SignalledExitCode(t *testing.T) {
+	run := &jobRun{args: []string{"/bin/sh", "-c", "kill -KILL $$"}, stop: watchStop(nil, nil)}
+	report := run.attempt("exec-1")
+	// The exit code stays -1 as before signals were reported; the signal says why.
+	if report.ExitCode != -1 || report.Signal != "SIGKILL" {
+		t.Errorf("exitCode = %d, signal = %q, want -1 and SIGKILL", report.ExitCode, report.Signal)
+	}
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/cronexpr"
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// schedulerInternal runs jobs from the daemon itself, for hosts without cron
+// or systemd (distroless containers, sidecars).
+const schedulerInternal = "internal"
+
+const (
+	// maxSchedulerSleep bounds how long the scheduler sleeps between checks.
+	// Timers follow the monotonic clock, which may stop during suspend, so the
+	// wall clock is re-read at least this often to notice suspend and jumps.
+	maxSchedulerSleep = 30 * time.Second
+	// missedRunWindow is how late a run may start after its scheduled time,
+	// e.g. after suspend or a forward clock jump. Older missed runs are skipped.
+	// Several missed runs of one job are coalesced into a single catch-up run.
+	// Runs missed while the daemon itself was down are not caught up: fire
+	// times are only kept in memory, so after a restart each job continues with
+	// its next scheduled time.
+	missedRunWindow = time.Hour
+	// clockJumpTolerance is how far the wall clock may go backwards before the
+	// scheduler recomputes all fire times.
+	clockJumpTolerance = time.Minute
+)
+
+// clock abstracts time so the scheduler can be tested deterministically.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+type scheduledJob struct {
+	job      protocol.JobDefinition
+	schedule *cronexpr.Schedule
+	loc      *time.Location
+	delay    time.Duration // Per-host jitter added to every fire time
+	next     time.Time     // Scheduled time of the next run; zero if it never fires
+}
+
+// fireAt returns when the next run starts, after its jitter delay.
+func (e *scheduledJob) fireAt() time.Time {
+	return e.next.Add(e.delay)
+}
+
+// advance sets next to the first scheduled time whose run starts after now.
+func (e *scheduledJob) advance(now time.Time) {
+	e.next = e.schedule.Next(now.Add(-e.delay).In(e.loc))
+}
+
+// launchFunc starts a run and returns a channel that is closed when it ends,
+// or nil if it could not be started.
+type launchFunc func(job protocol.JobDefinition, scheduledAt time.Time, delay time.Duration) <-chan struct{}
+
+// internalScheduler fires jobs at their cron schedule. Jobs are launched via
+// launch, which normally starts exec mode. The scheduler applies each job's
+// jitter itself, on its own clock, and skips runs of "forbid" jobs whose
+// previous run is still active; exec mode's lock still handles "replace" and
+// manual runs.
+type internalScheduler struct {
+	clock  clock
+	launch launchFunc
+	// skip is called for runs not started because of the concurrency policy.
+	skip func(job protocol.JobDefinition, scheduledAt time.Time)
+
+	mu      sync.Mutex
+	jobs    map[string]*scheduledJob
+	running map[string]<-chan struct{} // Latest run of each job, by job ID
+	lastNow time.Time
+	update  chan struct{}
+}
+
+func newInternalScheduler(c clock, launch launchFunc) *internalScheduler {
+	return &internalScheduler{
+		clock:   c,
+		launch:  launch,
+		jobs:    make(map[string]*scheduledJob),
+		running: make(map[string]<-chan struct{}),
+		update:  make(chan struct{}, 1),
+	}
+}
+
+// setJobs replaces the scheduled jobs. Jobs whose schedule is unchanged keep
+// their next fire time.
+func (s *internalScheduler) setJobs(jobs []protocol.JobDefinition) error {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := make(map[string]*scheduledJob, len(jobs))
+	for _, job := range jobs {
+		schedule, err := cronexpr.Parse(job.CronExpression)
+		if err != nil {
+			return err
+		}
+		loc := time.Local
+		if job.Timezone != "" {
+			if loc, err = time.LoadLocation(job.Timezone); err != nil {
+				return err
+			}
+		}
+
+		entry := &scheduledJob{job: job, schedule: schedule, loc: loc}
+		entry.delay = startDelay(getHostname(), job.JobID, job.JitterSeconds)
+		if old := s.jobs[job.JobID]; old != nil && old.schedule.String() == schedule.String() && old.loc.String() == loc.String() && old.delay == entry.delay {
+			entry.next = old.next
+		} else {
+			entry.advance(now)
+		}
+		updated[job.JobID] = entry
+	}
+	s.jobs = updated
+
+	select {
+	case s.update <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run fires jobs until stop is closed.
+func (s *internalScheduler) run(stop <-chan struct{}) {
+	for {
+		wait := s.step()
+		select {
+		case <-s.clock.After(wait):
+		case <-s.update:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// step launches due jobs and returns how long to sleep before the next check.
+func (s *internalScheduler) step() time.Duration {
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	if !s.lastNow.IsZero() && now.Before(s.lastNow.Add(-clockJumpTolerance)) {
+		// Fire times computed before a backwards jump could be far in the future.
+		log.Printf("Clock moved backwards by %v; recomputing schedules", s.lastNow.Sub(now).Round(time.Second))
+		for _, entry := range s.jobs {
+			entry.advance(now)
+		}
+	}
+	s.lastNow = now
+
+	type launch struct {
+		job         protocol.JobDefinition
+		scheduledAt time.Time
+		delay       time.Duration
+	}
+	var due []launch
+	wait := maxSchedulerSleep
+	for _, entry := range s.jobs {
+		if entry.next.IsZero() {
+			continue
+		}
+		if !entry.fireAt().After(now) {
+			if late := now.Sub(entry.fireAt()); late <= missedRunWindow {
+				due = append(due, launch{entry.job, entry.next, entry.delay})
+			} else {
+				log.Printf("Skipping missed run of job %s scheduled at %v (%v late)",
+					entry.job.JobID, entry.next.Format(time.RFC3339), late.Round(time.Second))
+			}
+			// Coalesce any further missed runs: continue from now.
+			entry.advance(now)
+			if entry.next.IsZero() {
+				continue
+			}
+		}
+		if d := entry.fireAt().Sub(now); d < wait {
+			wait = d
+		}
+	}
+	s.mu.Unlock()
+
+	// Launch in schedule order, outside the lock.
+	sort.Slice(due, func(i, j int) bool { return due[i].scheduledAt.Before(due[j].scheduledAt) })
+	for _, l := range due {
+		if l.job.ConcurrencyPolicy == concurrencyForbid && s.isRunning(l.job.JobID) {
+			log.Printf("Skipping run of job %s scheduled at %v: previous run is still active",
+				l.job.JobID, l.scheduledAt.Format(time.RFC3339))
+			if s.skip != nil {
+				s.skip(l.job, l.scheduledAt)
+			}
+			continue
+		}
+		done := s.launch(l.job, l.scheduledAt, l.delay)
+		s.mu.Lock()
+		s.running[l.job.JobID] = done
+		s.mu.Unlock()
+	}
+	return wait
+}
+
+// isRunning reports whether the latest run the scheduler started for a job is
+// still active.
+func (s *internalScheduler) isRunning(jobID string) bool {
+	s.mu.Lock()
+	done := s.running[jobID]
+	s.mu.Unlock()
+	if done == nil {
+		return false
+	}
+	select {
+	case <-done:
+		return false
+	default:
+		return true
+	}
+}
+
+// syncInternalJobs hands jobs to the internal scheduler, starting it on first
+// use. It returns a summary of the schedule for hashing.
+func (d *daemon) syncInternalJobs(jobs []protocol.JobDefinition) ([]byte, error) {
+	d.internalOnce.Do(func() {
+		d.internal = newInternalScheduler(realClock{}, func(job protocol.JobDefinition, scheduledAt time.Time, delay time.Duration) <-chan struct{} {
+			log.Printf("Starting job %s scheduled at %s", job.JobID, scheduledAt.Format(time.RFC3339))
+			// The scheduler has already waited for the jitter delay.
+			job.JitterSeconds = 0
+			done, err := spawnExec(execArgs(job, "--start-delay-ms", strconv.FormatInt(delay.Milliseconds(), 10)))
+			if err != nil {
+				log.Printf("Failed to start job %s: %v", job.JobID, err)
+			}
+			return done
+		})
+		d.internal.skip = d.reportSkippedRun
+		go d.internal.run(nil)
+	})
+
+	if err := d.internal.setJobs(jobs); err != nil {
+		return nil, err
+	}
+	log.Printf("Internal scheduler updated with %d jobs", len(jobs))
+
+	var summary []byte
+	for _, job := range jobs {
+		line := job.CronExpression + " " + job.JobID + "\n"
+		if job.Timezone != "" {
+			line = "TZ=" + job.Timezone + " " + line
+		}
+		summary = append(summary, line...)
+	}
+	return summary, nil
+}
+
+// reportSkippedRun reports a scheduled run that the internal scheduler did not
+// start, in the same form as exec mode's report for a run skipped by its lock.
+func (d *daemon) reportSkippedRun(job protocol.JobDefinition, scheduledAt time.Time) {
+	d.deliverReport(protocol.ExecutionReportPayload{
+		ExecutionID: newExecutionID(),
+		JobID:       job.JobID,
+		Command:     "/bin/sh -c " + job.Command,
+		Stderr:      fmt.Sprintf("Run skipped (concurrency policy %q): %v", job.ConcurrencyPolicy, errJobLocked),
+		StartTime:   scheduledAt.Format(time.RFC3339),
+		Status:      statusSkipped,
+		Trigger:     triggerScheduled,
+	})
+}
@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// fakeClock is a manually driven clock. After channels fire when the clock is
+// moved past their deadline.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// set moves the clock, forwards or backwards, and fires due waiters.
+func (c *fakeClock) set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	kept := c.waiters[:0]
+	for _, w := range c.waiters {
+		if !w.at.After(t) {
+			w.ch <- t
+		} else {
+			kept = append(kept, w)
+		}
+	}
+	c.waiters = kept
+}
+
+type launchRecord struct {
+	jobID       string
+	scheduledAt time.Time
+	startedAt   time.Time
+	delay       time.Duration
+	done        chan struct{} // Close to end the run
+}
+
+func newTestScheduler(start time.Time, jobs ...protocol.JobDefinition) (*internalScheduler, *fakeClock, *[]launchRecord) {
+	c := &fakeClock{now: start}
+	var launches []launchRecord
+	s := newInternalScheduler(c, func(job protocol.JobDefinition, at time.Time, delay time.Duration) <-chan struct{} {
+		done := make(chan struct{})
+		launches = append(launches, launchRecord{job.JobID, at, c.Now(), delay, done})
+		return done
+	})
+	if err := s.setJobs(jobs); err != nil {
+		panic(err)
+	}
+	return s, c, &launches
+}
+
+func at(hour, min int) time.Time {
+	return time.Date(2024, 5, 1, hour, min, 0, 0, time.Local)
+}
+
+func TestInternalScheduler_FiresOnSchedule(t *testing.T) {
+	s, c, launches := newTestScheduler(at(10, 0).Add(30*time.Second),
+		protocol.JobDefinition{JobID: "every-5", CronExpression: "*/5 * * * *"})
+
+	if wait := s.step(); len(*launches) != 0 || wait != maxSchedulerSleep {
+		t.Fatalf("step before schedule: launches=%v wait=%v", *launches, wait)
+	}
+
+	c.set(at(10, 5))
+	wait := s.step()
+	if len(*launches) != 1 || !(*launches)[0].scheduledAt.Equal(at(10, 5)) {
+		t.Fatalf("launches = %v, want one run at 10:05", *launches)
+	}
+	if wait != maxSchedulerSleep {
+		t.Errorf("wait = %v, want the sleep cap", wait)
+	}
+
+	// The same fire time is never launched twice.
+	s.step()
+	if len(*launches) != 1 {
+		t.Errorf("launches = %v, want no duplicate", *launches)
+	}
+}
+
+func TestInternalScheduler_CatchUpAfterSuspend(t *testing.T) {
+	s, c, launches := newTestScheduler(at(10, 0),
+		protocol.JobDefinition{JobID: "every-5", CronExpression: "*/5 * * * *"},
+		protocol.JobDefinition{JobID: "daily", CronExpression: "0 9 * * *"})
+
+	// Resume 40 minutes later: seven missed runs coalesce into one catch-up run.
+	c.set(at(10, 40).Add(10 * time.Second))
+	s.step()
+	if len(*launches) != 1 || (*launches)[0].jobID != "every-5" || !(*launches)[0].scheduledAt.Equal(at(10, 5)) {
+		t.Fatalf("launches = %v, want one catch-up run of every-5", *launches)
+	}
+
+	// The next run is back on schedule.
+	c.set(at(10, 45))
+	s.step()
+	if len(*launches) != 2 || !(*launches)[1].scheduledAt.Equal(at(10, 45)) {
+		t.Errorf("launches = %v, want a run at 10:45", *launches)
+	}
+}
+
+func TestInternalScheduler_SkipsRunsOutsideWindow(t *testing.T) {
+	s, c, launches := newTestScheduler(at(10, 0),
+		protocol.JobDefinition{JobID: "every-5", CronExpression: "*/5 * * * *"})
+
+	c.set(at(10, 5).Add(missedRunWindow + time.Minute))
+	s.step()
+	if len(*launches) != 0 {
+		t.Errorf("launches = %v, want stale run skipped", *launches)
+	}
+}
+
+func TestInternalScheduler_ClockJumpBackwards(t *testing.T) {
+	s, c, launches := newTestScheduler(at(10, 30),
+		protocol.JobDefinition{JobID: "hourly", CronExpression: "0 * * * *"})
+	s.step()
+
+	// Without recomputing, the 11:00 fire time would be a day away.
+	c.set(at(10, 30).Add(-24 * time.Hour))
+	s.step()
+	c.set(at(11, 0).Add(-24 * time.Hour))
+	s.step()
+	if len(*launches) != 1 || !(*launches)[0].scheduledAt.Equal(at(11, 0).Add(-24*time.Hour)) {
+		t.Errorf("launches = %v, want a run at 11:00 on the earlier day", *launches)
+	}
+}
+
+func TestInternalScheduler_RunLoop(t *testing.T) {
+	c := &fakeClock{now: at(10, 0)}
+	fired := make(chan time.Time, 10)
+	s := newInternalScheduler(c, func(job protocol.JobDefinition, at time.Time, delay time.Duration) <-chan struct{} {
+		fired <- at
+		return nil
+	})
+	s.setJobs([]protocol.JobDefinition{{JobID: "j", CronExpression: "* * * * *"}})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.run(stop)
+
+	// Advance in small steps until the loop has observed the fire time.
+	deadline := time.After(2 * time.Second)
+	for now := at(10, 0); ; now = now.Add(10 * time.Second) {
+		select {
+		case got := <-fired:
+			if !got.Equal(at(10, 1)) {
+				t.Errorf("fired at %v, want 10:01", got)
+			}
+			return
+		case <-deadline:
+			t.Fatal("scheduler did not fire")
+		case <-time.After(10 * time.Millisecond):
+			c.set(now)
+		}
+	}
+}
+
+func TestInternalScheduler_Timezone(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	start := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	s, c, launches := newTestScheduler(start,
+		protocol.JobDefinition{JobID: "berlin", CronExpression: "0 2 * * *", Timezone: "Europe/Berlin"})
+
+	// 02:00 in Berlin is 00:00 UTC in summer.
+	c.set(time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC))
+	s.step()
+	if len(*launches) != 1 || !(*launches)[0].scheduledAt.Equal(time.Date(2024, 7, 2, 2, 0, 0, 0, berlin)) {
+		t.Errorf("launches = %v, want a run at 02:00 Berlin time", *launches)
+	}
+}
+
+func TestInternalScheduler_Jitter(t *testing.T) {
+	job := protocol.JobDefinition{JobID: "jittered", CronExpression: "0 * * * *", JitterSeconds: 600}
+	delay := startDelay(getHostname(), job.JobID, job.JitterSeconds)
+	if delay < time.Second {
+		t.Skipf("jitter delay %v too short for this host", delay)
+	}
+	s, c, launches := newTestScheduler(at(10, 30), job)
+
+	// The scheduled time passes, but the run waits for the host's delay.
+	c.set(at(11, 0))
+	if wait := s.step(); len(*launches) != 0 || wait > delay {
+		t.Fatalf("step at 11:00: launches=%v wait=%v, want a wait of at most %v", *launches, wait, delay)
+	}
+
+	c.set(at(11, 0).Add(delay))
+	s.step()
+	if len(*launches) != 1 {
+		t.Fatalf("launches = %v, want one run after the delay", *launches)
+	}
+	l := (*launches)[0]
+	if !l.scheduledAt.Equal(at(11, 0)) || !l.startedAt.Equal(at(11, 0).Add(delay)) || l.delay != delay {
+		t.Errorf("launch = %+v, want scheduled at 11:00 and started %v later", l, delay)
+	}
+
+	// A daemon started between the scheduled time and the delayed start still runs it.
+	s, c, launches = newTestScheduler(at(12, 0).Add(delay/2), job)
+	c.set(at(12, 0).Add(delay))
+	s.step()
+	if len(*launches) != 1 || !(*launches)[0].scheduledAt.Equal(at(12, 0)) {
+		t.Errorf("launches = %v, want the 12:00 run", *launches)
+	}
+}
+
+func TestInternalScheduler_ForbidSkipsWhileRunning(t *testing.T) {
+	s, c, launches := newTestScheduler(at(10, 0),
+		protocol.JobDefinition{JobID: "slow", CronExpression: "*/5 * * * *", ConcurrencyPolicy: concurrencyForbid},
+		protocol.JobDefinition{JobID: "overlapping", CronExpression: "*/5 * * * *"})
+	var skipped []launchRecord
+	s.skip = func(job protocol.JobDefinition, at time.Time) {
+		skipped = append(skipped, launchRecord{jobID: job.JobID, scheduledAt: at})
+	}
+
+	c.set(at(10, 5))
+	s.step()
+	if len(*launches) != 2 {
+		t.Fatalf("launches = %v, want both jobs started", *launches)
+	}
+
+	// Neither run has ended: only the job that allows overlap starts again.
+	c.set(at(10, 10))
+	s.step()
+	if len(*launches) != 3 || (*launches)[2].jobID != "overlapping" {
+		t.Fatalf("launches = %v, want only the overlapping job", *launches)
+	}
+	if len(skipped) != 1 || skipped[0].jobID != "slow" || !skipped[0].scheduledAt.Equal(at(10, 10)) {
+		t.Fatalf("skipped = %v, want the 10:10 run of slow", skipped)
+	}
+
+	// Once the first run ends, the next one starts on schedule.
+	for _, l := range *launches {
+		if l.jobID == "slow" {
+			close(l.done)
+		}
+	}
+	c.set(at(10, 15))
+	s.step()
+	var slow int
+	for _, l := range *launches {
+		if l.jobID == "slow" {
+			slow++
+		}
+	}
+	if slow != 2 || len(skipped) != 1 {
+		t.Errorf("slow started %d times and skipped %v, want a second run at 10:15", slow, skipped)
+	}
+}
@@ -0,0 +1,254 @@
+package cmd
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+const (
+	secretsKeyFile   = "secrets.key"
+	secretsStoreFile = "secrets.enc"
+	secretsKeySize   = 32 // AES-256
+	maxSecretsSize   = 4 * 1024 * 1024
+)
+
+// secretsKeyPath overrides where the store's key is kept (secrets_key_file);
+// empty means secretsKeyFile in the state directory.
+//
+// The key only protects the store when the two are kept apart: with the
+// default layout, anyone who can read the state directory (or a backup of it)
+// can decrypt the secrets. Keeping the key on another path, e.g. a root-only
+// directory excluded from backups, limits such a leak to ciphertext. Neither
+// layout protects against root on the host, which the agent must trust.
+var secretsKeyPath string
+
+// secretNamePattern restricts secret names sent by the server. Names double as
+// temp file names, so they must not start with a dot.
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// validateSecretRefs checks a job's secret references.
+func validateSecretRefs(refs []protocol.SecretRef) error {
+	for _, ref := range refs {
+		if !secretNamePattern.MatchString(ref.Name) {
+			return fmt.Errorf("invalid secret name %q", ref.Name)
+		}
+		if !envNamePattern.MatchString(ref.Env) {
+			return fmt.Errorf("invalid environment variable name %q for secret %q", ref.Env, ref.Name)
+		}
+	}
+	return nil
+}
+
+// secretsKeyLocation returns the path of the store's key for a state directory.
+func secretsKeyLocation(baseDir string) string {
+	if secretsKeyPath != "" {
+		return secretsKeyPath
+	}
+	return filepath.Join(baseDir, secretsKeyFile)
+}
+
+// loadSecretsKey returns the store's encryption key, creating it if create is set.
+func loadSecretsKey(baseDir string, create bool) ([]byte, error) {
+	path := secretsKeyLocation(baseDir)
+	key, err := readPrivateFile(path, secretsKeySize)
+	if err == nil {
+		if len(key) != secretsKeySize {
+			return nil, fmt.Errorf("secrets key %s is corrupt", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) || !create {
+		return nil, err
+	}
+
+	key = make([]byte, secretsKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// O_EXCL: never overwrite a key created concurrently, or existing secrets become unreadable.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// saveSecrets replaces the contents of the encrypted secret store.
+// SECURITY: Values are encrypted with AES-256-GCM; both the key and the store are
+// mode 0600 and owned by the daemon user (root in system mode). See
+// secretsKeyPath for what the encryption does and does not protect against.
+func saveSecrets(baseDir string, secrets map[string]string) error {
+	key, err := loadSecretsKey(baseDir, true)
+	if err != nil {
+		return fmt.Errorf("failed to load secrets key: %w", err)
+	}
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+
+	path := filepath.Join(baseDir, secretsStoreFile)
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, sealed, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
+
+// loadSecrets decrypts the secret store. A missing store is an empty store.
+func loadSecrets(baseDir string) (map[string]string, error) {
+	sealed, err := readPrivateFile(filepath.Join(baseDir, secretsStoreFile), maxSecretsSize)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := loadSecretsKey(baseDir, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secrets key: %w", err)
+	}
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("secret store is corrupt")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret store: %w", err)
+	}
+
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("secret store is corrupt: %w", err)
+	}
+	return secrets, nil
+}
+
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readPrivateFile reads at most limit bytes without following symlinks.
+func readPrivateFile(path string, limit int64) ([]byte, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, limit))
+}
+
+// injectedSecrets holds the environment and temp files prepared for one run.
+type injectedSecrets struct {
+	env    map[string]string
+	values []string
+	tmpDir string
+}
+
+// injectSecrets resolves a job's secret references. Env references receive the
+// value directly; file references receive the path of a private temp file owned
+// by the job's user. The caller must call cleanup once the job has finished.
+func injectSecrets(baseDir string, refs []protocol.SecretRef, credential *syscall.Credential) (*injectedSecrets, error) {
+	inj := &injectedSecrets{env: make(map[string]string)}
+	if len(refs) == 0 {
+		return inj, nil
+	}
+
+	store, err := loadSecrets(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		value, ok := store[ref.Name]
+		if !ok {
+			inj.cleanup()
+			return nil, fmt.Errorf("secret %q is not available on this host", ref.Name)
+		}
+		inj.values = append(inj.values, value)
+
+		if !ref.File {
+			inj.env[ref.Env] = value
+			continue
+		}
+
+		path, err := inj.writeFile(ref.Name, value, credential)
+		if err != nil {
+			inj.cleanup()
+			return nil, fmt.Errorf("failed to materialize secret %q: %w", ref.Name, err)
+		}
+		inj.env[ref.Env] = path
+	}
+	return inj, nil
+}
+
+func (inj *injectedSecrets) writeFile(name, value string, credential *syscall.Credential) (string, error) {
+	if inj.tmpDir == "" {
+		dir, err := os.MkdirTemp("", "cc-secrets-")
+		if err != nil {
+			return "", err
+		}
+		inj.tmpDir = dir
+		if credential != nil {
+			if err := os.Chown(dir, int(credential.Uid), int(credential.Gid)); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	path := filepath.Join(inj.tmpDir, name)
+	if err := os.WriteFile(path, []byte(value), 0400); err != nil {
+		return "", err
+	}
+	if credential != nil {
+		if err := os.Chown(path, int(credential.Uid), int(credential.Gid)); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// cleanup removes any temp files written for the run.
+func (inj *injectedSecrets) cleanup() {
+	if inj.tmpDir != "" {
+		os.RemoveAll(inj.tmpDir)
+	}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestSecrets_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := map[string]string{"db_password": "hunter2-long", "api_token": "tok-123456"}
+
+	if err := saveSecrets(dir, want); err != nil {
+		t.Fatalf("saveSecrets failed: %v", err)
+	}
+
+	// The store must not contain plaintext values.
+	raw, err := os.ReadFile(filepath.Join(dir, secretsStoreFile))
+	if err != nil {
+		t.Fatalf("Failed to read store: %v", err)
+	}
+	if bytes.Contains(raw, []byte("hunter2-long")) {
+		t.Errorf("Secret store contains plaintext value")
+	}
+
+	for _, name := range []string{secretsKeyFile, secretsStoreFile} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", name, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("%s has mode %v, want 0600", name, info.Mode().Perm())
+		}
+	}
+
+	got, err := loadSecrets(dir)
+	if err != nil {
+		t.Fatalf("loadSecrets failed: %v", err)
+	}
+	if len(got) != len(want) || got["db_password"] != want["db_password"] || got["api_token"] != want["api_token"] {
+		t.Errorf("loadSecrets() = %v, want %v", got, want)
+	}
+
+	// Tampering must be detected rather than yielding garbage.
+	raw[len(raw)-1] ^= 0xff
+	os.WriteFile(filepath.Join(dir, secretsStoreFile), raw, 0600)
+	if _, err := loadSecrets(dir); err == nil {
+		t.Errorf("Expected tampered store to fail decryption")
+	}
+}
+
+func TestSecrets_SeparateKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(t.TempDir(), "secrets.key")
+	secretsKeyPath = keyPath
+	t.Cleanup(func() { secretsKeyPath = "" })
+
+	if err := saveSecrets(dir, map[string]string{"token": "s3cr3t-token"}); err != nil {
+		t.Fatalf("saveSecrets failed: %v", err)
+	}
+	if _, err := os.Stat(keyPath); err != nil {
+		t.Errorf("Key not written to %s: %v", keyPath, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, secretsKeyFile)); !os.IsNotExist(err) {
+		t.Errorf("Key written to the state directory (stat: %v)", err)
+	}
+
+	// The state directory alone is not enough to decrypt the store.
+	secretsKeyPath = ""
+	if _, err := loadSecrets(dir); err == nil {
+		t.Errorf("loadSecrets() without the key succeeded")
+	}
+
+	secretsKeyPath = keyPath
+	job := protocol.JobDefinition{JobID: "j", Command: "true", Secrets: []protocol.SecretRef{{Name: "token", Env: "TOKEN"}}}
+	if args := strings.Join(execArgs(job), " "); !strings.Contains(args, " --secrets-key "+keyPath+" ") {
+		t.Errorf("execArgs() = %q, want --secrets-key", args)
+	}
+}
+
+func TestInjectSecrets(t *testing.T) {
+	dir := t.TempDir()
+	if err := saveSecrets(dir, map[string]string{"token": "s3cr3t-token", "cert": "-----PEM-----"}); err != nil {
+		t.Fatalf("saveSecrets failed: %v", err)
+	}
+
+	refs := []protocol.SecretRef{
+		{Name: "token", Env: "API_TOKEN"},
+		{Name: "cert", Env: "CERT_FILE", File: true},
+	}
+	inj, err := injectSecrets(dir, refs, nil)
+	if err != nil {
+		t.Fatalf("injectSecrets failed: %v", err)
+	}
+
+	if inj.env["API_TOKEN"] != "s3cr3t-token" {
+		t.Errorf("API_TOKEN = %q", inj.env["API_TOKEN"])
+	}
+	data, err := os.ReadFile(inj.env["CERT_FILE"])
+	if err != nil || string(data) != "-----PEM-----" {
+		t.Errorf("CERT_FILE contents = %q, %v", data, err)
+	}
+
+	var r redactor
+	r.addValues(inj.values...)
+	if got := r.redact("token=s3cr3t-token"); got != "token="+redactedPlaceholder {
+		t.Errorf("redact() = %q", got)
+	}
+
+	inj.cleanup()
+	if _, err := os.Stat(inj.env["CERT_FILE"]); !os.IsNotExist(err) {
+		t.Errorf("Expected secret file to be removed, got %v", err)
+	}
+
+	if _, err := injectSecrets(dir, []protocol.SecretRef{{Name: "missing", Env: "X"}}, nil); err == nil {
+		t.Errorf("Expected error for unknown secret")
+	}
+}
+
+func TestSyncSecrets(t *testing.T) {
+	defer func(old string) { stateDir = old }(stateDir)
+	stateDir = t.TempDir()
+	d := &daemon{}
+
+	d.handleMessage([]byte(`{"type":"sync_secrets","secrets":{"db_password":"hunter2-long"}}`))
+
+	raw, err := os.ReadFile(filepath.Join(stateDir, secretsStoreFile))
+	if err != nil || bytes.Contains(raw, []byte("hunter2-long")) {
+		t.Fatalf("secret store not written encrypted: %v", err)
+	}
+	if got, err := loadSecrets(stateDir); err != nil || got["db_password"] != "hunter2-long" {
+		t.Errorf("loadSecrets() = %v, %v", got, err)
+	}
+	// Reports forwarded by the daemon are redacted even if exec mode missed a value.
+	if got := d.newReportRedactor().redact("pw=hunter2-long"); got != "pw="+redactedPlaceholder {
+		t.Errorf("redact() = %q, want the secret redacted", got)
+	}
+
+	// A secret too short to redact is rejected, leaving the store unchanged.
+	d.handleMessage([]byte(`{"type":"sync_secrets","secrets":{"db_password":"hunter2-long","pin":"123"}}`))
+	if got, _ := loadSecrets(stateDir); len(got) != 1 || got["pin"] != "" {
+		t.Errorf("store after a sync with a short secret = %v, want unchanged", got)
+	}
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// spoolScanInterval is how often the daemon picks up reports spooled by exec mode.
+const spoolScanInterval = 30 * time.Second
+
+// spoolDir returns the directory exec mode falls back to when the daemon socket
+// is unreachable (e.g. while the daemon restarts or upgrades).
+func spoolDir(baseDir string) string {
+	return filepath.Join(baseDir, "spool")
+}
+
+// spoolReport writes a report into the spool directory for the daemon to forward later.
+// The file is written under a temporary name and renamed so the daemon never sees
+// a partial report.
+func spoolReport(dir string, report protocol.ExecutionReportPayload) error {
+	if !validExecutionID(report.ExecutionID) {
+		return fmt.Errorf("invalid execution ID %q", report.ExecutionID)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	// The daemon may never have run, or the state directory may have been
+	// cleaned since, so the wrapper cannot rely on it to create the spool.
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, report.ExecutionID+".json")
+	tmpFile := filepath.Join(dir, "."+report.ExecutionID+".tmp")
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+	return nil
+}
+
+// watchSpool forwards spooled reports on startup and then periodically.
+func (d *daemon) watchSpool(dir string) {
+	// SECURITY: Only the daemon user (and root exec wrappers) may write here.
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Failed to create spool directory: %v", err)
+		return
+	}
+
+	d.drainSpool(dir)
+
+	ticker := time.NewTicker(spoolScanInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.drainSpool(dir)
+	}
+}
+
+// drainSpool forwards each spooled report through the normal delivery path and
+// removes it. Delivery stores the report in the outbox first, so once the spool
+// file is gone the outbox owns retransmission. A report that could be neither
+// stored nor sent stays in the spool for the next scan.
+func (d *daemon) drainSpool(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Failed to read spool directory: %v", err)
+		return
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+
+		report, err := readSpooledReport(path)
+		if err != nil {
+			log.Printf("Discarding unreadable spooled report %s: %v", name, err)
+			os.Remove(path)
+			continue
+		}
+
+		log.Printf("Forwarding spooled execution report: job=%s, exitCode=%d", report.JobID, report.ExitCode)
+		if err := d.deliverReport(report); err != nil {
+			log.Printf("Keeping spooled report %s for the next scan: %v", name, err)
+			continue
+		}
+		os.Remove(path)
+	}
+}
+
+func readSpooledReport(path string) (protocol.ExecutionReportPayload, error) {
+	var report protocol.ExecutionReportPayload
+
+	// SECURITY: Do not follow symlinks planted in the spool directory.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return report, err
+	}
+	defer f.Close()
+
+	// SECURITY: Apply the same size limit as the socket listener.
+	decoder := json.NewDecoder(io.LimitReader(f, maxReportSize))
+	if err := decoder.Decode(&report); err != nil {
+		return report, err
+	}
+	return report, nil
+}
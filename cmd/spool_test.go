@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestDrainSpool_KeepsUndeliveredReports(t *testing.T) {
+	dir := t.TempDir()
+	if err := spoolReport(dir, protocol.ExecutionReportPayload{ExecutionID: "run-1", JobID: "job"}); err != nil {
+		t.Fatalf("spoolReport failed: %v", err)
+	}
+
+	// Without an outbox or a server connection the report cannot go anywhere.
+	d := &daemon{}
+	d.drainSpool(dir)
+	if _, err := os.Stat(filepath.Join(dir, "run-1.json")); err != nil {
+		t.Fatalf("Spooled report was removed although delivery failed: %v", err)
+	}
+
+	// Once the outbox accepts it, the spool file is handed over.
+	ob, err := newOutbox(filepath.Join(t.TempDir(), "outbox"), 0, 0)
+	if err != nil {
+		t.Fatalf("newOutbox failed: %v", err)
+	}
+	d.outbox = ob
+	d.drainSpool(dir)
+	if _, err := os.Stat(filepath.Join(dir, "run-1.json")); !os.IsNotExist(err) {
+		t.Errorf("Spooled report still present after delivery: %v", err)
+	}
+	entries, err := ob.pending()
+	if err != nil || len(entries) != 1 || entries[0].id != "run-1" {
+		t.Errorf("Outbox entries = %+v, %v; want run-1", entries, err)
+	}
+}
+
+func TestDrainSpool_DiscardsUnreadableReports(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &daemon{}
+	d.drainSpool(dir)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Unreadable spooled report was not discarded: %v", err)
+	}
+}
+
+func TestSpoolReport_CreatesDirectory(t *testing.T) {
+	// The daemon has never run, so neither the state nor the spool directory exists.
+	dir := spoolDir(filepath.Join(t.TempDir(), "state"))
+	if err := spoolReport(dir, protocol.ExecutionReportPayload{ExecutionID: "run-1", JobID: "job"}); err != nil {
+		t.Fatalf("spoolReport failed: %v", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("spool directory = %v, %v; want mode 0700", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "run-1.json")); err != nil {
+		t.Errorf("report not spooled: %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// streamIdleTimeout bounds how long a streaming exec session may stay silent.
+// It must comfortably exceed streamKeepaliveInterval.
+const streamIdleTimeout = 2 * time.Minute
+
+var errMessageTooLarge = errors.New("socket message exceeds size limit")
+
+// messageLimitReader enforces a byte budget that is reset for every message
+// decoded from a socket connection. Unlike io.LimitReader it allows long-lived
+// streaming sessions while still bounding each individual message.
+type messageLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *messageLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// execStream is the daemon's view of one streaming exec session. The session
+// is also the channel for commands back to exec mode, such as cancellation.
+// A session lasts for a whole run: each retry attempt starts a new execution on
+// it, and cancelling any of the run's executions stops the run.
+type execStream struct {
+	executionID string   // Current attempt; output chunks must belong to it
+	ids         []string // All executions registered for this session
+	session     *execSession
+	redactors   map[string]*chunkRedactor // Per output stream
+}
+
+func newExecStream(conn net.Conn) *execStream {
+	return &execStream{session: &execSession{conn: conn, enc: json.NewEncoder(conn)}}
+}
+
+// registerStream tracks a running execution so it can be cancelled, and makes
+// it the session's current execution. Execution IDs are unique; a session
+// claiming an ID that is already registered is refused.
+func (d *daemon) registerStream(stream *execStream, executionID string) bool {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+	if d.streams == nil {
+		d.streams = make(map[string]*execStream)
+	}
+	if _, exists := d.streams[executionID]; exists {
+		return false
+	}
+	d.streams[executionID] = stream
+	stream.ids = append(stream.ids, executionID)
+	stream.executionID = executionID
+	stream.redactors = map[string]*chunkRedactor{"stdout": {}, "stderr": {}}
+	return true
+}
+
+func (d *daemon) unregisterStream(stream *execStream) {
+	d.streamsMu.Lock()
+	defer d.streamsMu.Unlock()
+	for _, id := range stream.ids {
+		if d.streams[id] == stream {
+			delete(d.streams, id)
+		}
+	}
+}
+
+// cancelExecution asks a running execution to stop and acknowledges the request.
+// Exec mode terminates the job's process group with a grace period and reports
+// the run as cancelled.
+func (d *daemon) cancelExecution(executionID, cancelledBy string) {
+	ack := protocol.CancelExecutionAckMessage{Type: "cancel_execution_ack", ExecutionID: executionID}
+
+	d.streamsMu.Lock()
+	stream := d.streams[executionID]
+	d.streamsMu.Unlock()
+
+	if stream == nil {
+		ack.Status = "not_found"
+		ack.Reason = "execution is not running on this agent"
+	} else if err := stream.session.send("cancel", cancelRequest{CancelledBy: cancelledBy}); err != nil {
+		ack.Status = "failed"
+		ack.Reason = err.Error()
+	} else {
+		ack.Status = "cancelling"
+	}
+	log.Printf("cancel_execution for %s by %q: %s", executionID, cancelledBy, ack.Status)
+
+	if err := d.sendMessage(ack); err != nil {
+		log.Printf("Failed to send cancel_execution_ack: %v", err)
+	}
+}
+
+// relayOutput redacts and forwards one output chunk.
+func (d *daemon) relayOutput(stream *execStream, chunk protocol.ExecutionOutputPayload) {
+	cr, ok := stream.redactors[chunk.Stream]
+	if !ok {
+		log.Printf("Ignoring output chunk for unknown stream %q", chunk.Stream)
+		return
+	}
+	// SECURITY: Live output is redacted exactly like final reports.
+	chunk.Data = cr.redact(chunk.Data, d.newReportRedactor())
+
+	d.relayLive(protocol.ExecutionOutputMessage{Type: "execution_output", Payload: chunk})
+}
+
+// relayLive forwards a live execution message to the server. Live messages are
+// best effort: they are neither queued nor retransmitted, and the server detects
+// gaps via sequence numbers. The final execution report is delivered durably.
+//
+// The send blocks while the WebSocket is busy, which stops reading from the
+// exec session; exec mode absorbs that backpressure in a bounded buffer.
+func (d *daemon) relayLive(msg interface{}) {
+	if !d.isRegistered() {
+		return
+	}
+	if err := d.sendMessage(msg); err != nil {
+		log.Printf("Failed to relay live execution message: %v", err)
+	}
+}
+
+// isRegistered reports whether the current connection has completed registration.
+func (d *daemon) isRegistered() bool {
+	d.outboxMu.Lock()
+	defer d.outboxMu.Unlock()
+	return d.registered
+}
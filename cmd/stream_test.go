@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestExecSession_CancelRunningExecution(t *testing.T) {
+	client, server := net.Pipe()
+	d := &daemon{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.handleSocketConnection(server)
+	}()
+
+	session := &execSession{conn: client, enc: json.NewEncoder(client)}
+	cancel := session.watchCancel()
+	if err := session.send("execution_started", protocol.ExecutionStartedPayload{ExecutionID: "exec-1", JobID: "job"}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	// Wait for the daemon to register the session.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		d.streamsMu.Lock()
+		_, ok := d.streams["exec-1"]
+		d.streamsMu.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("execution was not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d.cancelExecution("exec-1", "alice")
+	select {
+	case req := <-cancel:
+		if req.CancelledBy != "alice" {
+			t.Errorf("CancelledBy = %q, want alice", req.CancelledBy)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancel request was not delivered")
+	}
+
+	client.Close()
+	<-done
+	if len(d.streams) != 0 {
+		t.Errorf("execution still registered after session ended")
+	}
+}
+
+func TestExecSession_CancelBetweenAttempts(t *testing.T) {
+	client, server := net.Pipe()
+	d := &daemon{}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.handleSocketConnection(server)
+	}()
+
+	// A run with retries starts each attempt on the same session.
+	session := &execSession{conn: client, enc: json.NewEncoder(client)}
+	cancel := session.watchCancel()
+	for _, id := range []string{"attempt-1", "attempt-2"} {
+		if err := session.send("execution_started", protocol.ExecutionStartedPayload{ExecutionID: id, JobID: "job"}); err != nil {
+			t.Fatalf("send failed: %v", err)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		d.streamsMu.Lock()
+		n := len(d.streams)
+		d.streamsMu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("attempts were not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Cancelling an earlier attempt reaches the run, e.g. while it waits to retry.
+	d.cancelExecution("attempt-1", "bob")
+	select {
+	case req := <-cancel:
+		if req.CancelledBy != "bob" {
+			t.Errorf("CancelledBy = %q, want bob", req.CancelledBy)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cancel request was not delivered")
+	}
+
+	client.Close()
+	<-done
+	if len(d.streams) != 0 {
+		t.Errorf("executions still registered after session ended: %v", d.streams)
+	}
+}
+
+func TestHandleSocketConnection_EOFIsNormalClose(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// A session closed before execution_started, e.g. because the job failed to start.
+	client, server := net.Pipe()
+	client.Close()
+	(&daemon{}).handleSocketConnection(server)
+
+	if strings.Contains(logs.String(), "Failed to decode") {
+		t.Errorf("EOF was logged as an error: %q", logs.String())
+	}
+}
+
+func TestOpenExecSession_AnnouncesSession(t *testing.T) {
+	path := t.TempDir() + "/agent.sock"
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	opened := make(chan *execSession, 1)
+	go func() {
+		session, err := openExecSession(path)
+		if err != nil {
+			t.Error(err)
+		}
+		opened <- session
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if session := <-opened; session != nil {
+		defer session.close()
+	}
+
+	// The first message arrives right away, before any attempt has started, so
+	// the daemon keeps the session through a retry delay.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg socketMessage
+	if err := json.NewDecoder(conn).Decode(&msg); err != nil || msg.Type != "keepalive" {
+		t.Errorf("first message = %+v (%v), want keepalive", msg, err)
+	}
+}
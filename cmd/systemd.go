@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/croncommander/cc-agent/internal/cronexpr"
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+// Scheduling backends selected via the scheduler config key.
+const (
+	schedulerCron    = "cron"
+	schedulerSystemd = "systemd"
+)
+
+// unitPrefix marks unit files owned by the agent. Only files with this prefix
+// are ever removed.
+const unitPrefix = "cc-job-"
+
+// The system unit directory and systemctl binary, variables so tests can
+// replace them.
+var (
+	systemUnitDir    = "/etc/systemd/system"
+	systemctlCommand = "systemctl"
+)
+
+// safeUnitArg matches ExecStart arguments that need no quoting.
+var safeUnitArg = regexp.MustCompile(`^[A-Za-z0-9_+=:,./-]+$`)
+
+// systemdUnitDir returns the directory for the agent's unit files.
+func systemdUnitDir(systemMode bool) string {
+	if systemMode {
+		return systemUnitDir
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "systemd", "user")
+}
+
+// systemdRunPath returns the absolute path of systemd-run for ExecStart.
+func systemdRunPath() string {
+	if path, err := exec.LookPath("systemd-run"); err == nil {
+		return path
+	}
+	return "/usr/bin/systemd-run"
+}
+
+// generateSystemdUnits returns a .service/.timer pair per job, keyed by file name.
+//
+// A timer never starts a service that is still running, which would turn every
+// concurrency policy into "forbid" and skip runs without a report. The service
+// therefore only launches each run as its own transient unit via systemd-run,
+// and exec mode's lock applies the policy, as it does under cron.
+func generateSystemdUnits(jobs []protocol.JobDefinition, systemMode bool) (map[string][]byte, error) {
+	launcher := []string{systemdRunPath()}
+	if !systemMode {
+		launcher = append(launcher, "--user")
+	}
+	launcher = append(launcher, "--no-block", "--collect", "--quiet")
+
+	units := make(map[string][]byte, 2*len(jobs))
+	for _, job := range jobs {
+		schedule, err := cronexpr.Parse(job.CronExpression)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", job.JobID, err)
+		}
+		name := unitPrefix + jobFileName(job.JobID)
+		description := "CronCommander job " + escapeUnitValue(job.JobID)
+
+		var service bytes.Buffer
+		service.WriteString("# CronCommander managed unit. Do not edit.\n")
+		service.WriteString("[Unit]\n")
+		service.WriteString("Description=" + description + "\n\n")
+		service.WriteString("[Service]\n")
+		service.WriteString("Type=oneshot\n")
+		// The run itself is a transient unit; exec mode enforces the job's timeout.
+		service.WriteString("ExecStart=")
+		for _, arg := range launcher {
+			service.WriteString(quoteUnitArg(arg) + " ")
+		}
+		service.WriteString(quoteUnitArg("--description=CronCommander job " + job.JobID))
+		service.WriteString(" -- " + quoteUnitArg(agentExecutable()))
+		for _, arg := range execArgs(job) {
+			service.WriteString(" " + quoteUnitArg(arg))
+		}
+		service.WriteString("\n")
+
+		var timer bytes.Buffer
+		timer.WriteString("# CronCommander managed unit. Do not edit.\n")
+		timer.WriteString("[Unit]\n")
+		timer.WriteString("Description=Schedule for " + description + "\n\n")
+		timer.WriteString("[Timer]\n")
+		for _, calendar := range schedule.OnCalendar() {
+			if job.Timezone != "" {
+				calendar += " " + job.Timezone
+			}
+			timer.WriteString("OnCalendar=" + calendar + "\n")
+		}
+		// Fire on the minute like cron, instead of systemd's default 1min accuracy.
+		timer.WriteString("AccuracySec=1s\n\n")
+		timer.WriteString("[Install]\n")
+		timer.WriteString("WantedBy=timers.target\n")
+
+		units[name+".service"] = service.Bytes()
+		units[name+".timer"] = timer.Bytes()
+	}
+	return units, nil
+}
+
+// escapeUnitValue escapes systemd specifiers (%) in a unit setting value.
+func escapeUnitValue(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// quoteUnitArg quotes one ExecStart argument. Besides quoting, '%' specifiers
+// and '$' variable expansion are escaped so arguments reach exec verbatim.
+func quoteUnitArg(s string) string {
+	s = strings.ReplaceAll(escapeUnitValue(s), "$", "$$")
+	if safeUnitArg.MatchString(s) {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// syncSystemdUnits installs timer units for jobs, removes stale ones and
+// reloads systemd. It returns the installed unit content (for hashing) and
+// systemctl output.
+func (d *daemon) syncSystemdUnits(jobs []protocol.JobDefinition) ([]byte, string, error) {
+	systemMode := d.executionMode == "system"
+	units, err := generateSystemdUnits(jobs, systemMode)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir := systemdUnitDir(systemMode)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create unit directory: %w", err)
+	}
+
+	names := make([]string, 0, len(units))
+	for name := range units {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var output bytes.Buffer
+	if err := removeStaleUnits(dir, units, systemMode, &output); err != nil {
+		return nil, output.String(), err
+	}
+
+	var content bytes.Buffer
+	var timers []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		tmpFile := path + ".tmp"
+		if err := os.WriteFile(tmpFile, units[name], 0644); err != nil {
+			return nil, output.String(), fmt.Errorf("failed to write unit %s: %w", name, err)
+		}
+		if err := os.Rename(tmpFile, path); err != nil {
+			os.Remove(tmpFile)
+			return nil, output.String(), fmt.Errorf("failed to write unit %s: %w", name, err)
+		}
+		if strings.HasSuffix(name, ".timer") {
+			timers = append(timers, name)
+		}
+		content.WriteString("# " + name + "\n")
+		content.Write(units[name])
+	}
+
+	if err := systemctl(systemMode, &output, "daemon-reload"); err != nil {
+		return nil, output.String(), err
+	}
+	if len(timers) > 0 {
+		if err := systemctl(systemMode, &output, append([]string{"enable"}, timers...)...); err != nil {
+			return nil, output.String(), err
+		}
+		// Restart so changed schedules take effect immediately.
+		if err := systemctl(systemMode, &output, append([]string{"restart"}, timers...)...); err != nil {
+			return nil, output.String(), err
+		}
+	}
+
+	log.Printf("systemd timers updated with %d jobs in %s", len(jobs), dir)
+	return content.Bytes(), output.String(), nil
+}
+
+// removeSystemdUnits removes all of the agent's units, e.g. after switching
+// back to the cron backend. It does nothing if there are none.
+func (d *daemon) removeSystemdUnits() error {
+	systemMode := d.executionMode == "system"
+	dir := systemdUnitDir(systemMode)
+	matches, _ := filepath.Glob(filepath.Join(dir, unitPrefix+"*"))
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var output bytes.Buffer
+	if err := removeStaleUnits(dir, nil, systemMode, &output); err != nil {
+		return err
+	}
+	return systemctl(systemMode, &output, "daemon-reload")
+}
+
+// removeStaleUnits stops and deletes agent units in dir that are not in keep.
+func removeStaleUnits(dir string, keep map[string][]byte, systemMode bool, output *bytes.Buffer) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var stale, staleTimers []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, unitPrefix) {
+			continue
+		}
+		if _, ok := keep[name]; ok {
+			continue
+		}
+		stale = append(stale, name)
+		if strings.HasSuffix(name, ".timer") {
+			staleTimers = append(staleTimers, name)
+		}
+	}
+
+	if len(staleTimers) > 0 {
+		if err := systemctl(systemMode, output, append([]string{"disable", "--now"}, staleTimers...)...); err != nil {
+			log.Printf("Failed to disable stale timers: %v", err)
+		}
+	}
+	for _, name := range stale {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to remove stale unit %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// systemctl runs systemctl against the system or user manager, appending its
+// output to output.
+func systemctl(systemMode bool, output *bytes.Buffer, args ...string) error {
+	if !systemMode {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command(systemctlCommand, args...)
+	out, err := cmd.CombinedOutput()
+	output.Write(out)
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/croncommander/cc-agent/internal/protocol"
+)
+
+func TestGenerateSystemdUnits(t *testing.T) {
+	jobs := []protocol.JobDefinition{
+		{JobID: "report 100%", CronExpression: "30 2 * * 1-5", Command: `echo "$HOME" 50% done\now`},
+	}
+	units, err := generateSystemdUnits(jobs, false)
+	if err != nil {
+		t.Fatalf("generateSystemdUnits failed: %v", err)
+	}
+
+	name := unitPrefix + jobFileName("report 100%")
+	service := string(units[name+".service"])
+	timer := string(units[name+".timer"])
+	if service == "" || timer == "" {
+		t.Fatalf("missing units, got %d files", len(units))
+	}
+
+	if !strings.Contains(timer, "OnCalendar=Mon,Tue,Wed,Thu,Fri *-*-* 02:30:00\n") {
+		t.Errorf("unexpected timer:\n%s", timer)
+	}
+	// Specifiers and variables are escaped so the command reaches exec verbatim.
+	if !strings.Contains(service, `"echo \"$$HOME\" 50%% done\\now"`) {
+		t.Errorf("command not escaped for ExecStart:\n%s", service)
+	}
+	if !strings.Contains(service, "Description=CronCommander job report 100%%\n") {
+		t.Errorf("description not escaped:\n%s", service)
+	}
+}
+
+func TestGenerateSystemdUnits_RunsAsTransientUnit(t *testing.T) {
+	jobs := []protocol.JobDefinition{{JobID: "j", CronExpression: "* * * * *", Command: "true", ConcurrencyPolicy: concurrencyReplace}}
+	for _, systemMode := range []bool{false, true} {
+		units, err := generateSystemdUnits(jobs, systemMode)
+		if err != nil {
+			t.Fatalf("generateSystemdUnits failed: %v", err)
+		}
+		service := string(units[unitPrefix+jobFileName("j")+".service"])
+
+		// Each run is its own unit so that runs can overlap and exec mode's lock
+		// applies the concurrency policy.
+		var execStart string
+		for _, line := range strings.Split(service, "\n") {
+			if strings.HasPrefix(line, "ExecStart=") {
+				execStart = line
+			}
+		}
+		launcher, wrapper, ok := strings.Cut(execStart, " -- ")
+		if !ok || !strings.Contains(launcher, "systemd-run") || !strings.Contains(launcher, " --no-block ") {
+			t.Errorf("ExecStart does not launch a transient unit: %q", execStart)
+		}
+		if got := strings.Contains(launcher, " --user "); got == systemMode {
+			t.Errorf("system mode %v: --user in launcher = %v", systemMode, got)
+		}
+		if !strings.Contains(wrapper, " exec --job-id j ") || !strings.Contains(wrapper, " --concurrency-policy replace ") {
+			t.Errorf("ExecStart does not run exec mode with the job's policy: %q", execStart)
+		}
+	}
+}
+
+func TestGenerateSystemdUnits_Timezone(t *testing.T) {
+	jobs := []protocol.JobDefinition{
+		{JobID: "berlin", CronExpression: "0 12 1,15 * 0", Command: "true", Timezone: "Europe/Berlin"},
+	}
+	units, err := generateSystemdUnits(jobs, false)
+	if err != nil {
+		t.Fatalf("generateSystemdUnits failed: %v", err)
+	}
+	timer := string(units[unitPrefix+jobFileName("berlin")+".timer"])
+	for _, want := range []string{
+		"OnCalendar=*-*-01,15 12:00:00 Europe/Berlin\n",
+		"OnCalendar=Sun *-*-* 12:00:00 Europe/Berlin\n",
+	} {
+		if !strings.Contains(timer, want) {
+			t.Errorf("timer missing %q:\n%s", want, timer)
+		}
+	}
+}
+
+// fakeSystemctl replaces systemctl with a stub that logs its arguments, one
+// call per line, and points the system unit directory at a temp dir.
+func fakeSystemctl(t *testing.T) (unitDir, logFile string) {
+	dir := t.TempDir()
+	unitDir = filepath.Join(dir, "units")
+	logFile = filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "systemctl")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" >> \""+logFile+"\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	oldDir, oldCommand := systemUnitDir, systemctlCommand
+	systemUnitDir, systemctlCommand = unitDir, script
+	t.Cleanup(func() { systemUnitDir, systemctlCommand = oldDir, oldCommand })
+	return unitDir, logFile
+}
+
+func TestSyncSystemdUnits_RemovesStaleUnits(t *testing.T) {
+	unitDir, logFile := fakeSystemctl(t)
+	d := &daemon{executionMode: "system"}
+
+	jobs := []protocol.JobDefinition{
+		{JobID: "keep", CronExpression: "0 * * * *", Command: "true"},
+		{JobID: "old", CronExpression: "0 * * * *", Command: "true"},
+	}
+	if _, _, err := d.syncSystemdUnits(jobs); err != nil {
+		t.Fatalf("syncSystemdUnits failed: %v", err)
+	}
+	// Units not written by the agent are never touched.
+	other := filepath.Join(unitDir, "other.service")
+	os.WriteFile(other, []byte("[Service]\n"), 0644)
+	os.Remove(logFile)
+
+	if _, _, err := d.syncSystemdUnits(jobs[:1]); err != nil {
+		t.Fatalf("syncSystemdUnits failed: %v", err)
+	}
+
+	keep, old := unitPrefix+jobFileName("keep"), unitPrefix+jobFileName("old")
+	for _, name := range []string{keep + ".service", keep + ".timer", "other.service"} {
+		if _, err := os.Stat(filepath.Join(unitDir, name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+	for _, name := range []string{old + ".service", old + ".timer"} {
+		if _, err := os.Stat(filepath.Join(unitDir, name)); !os.IsNotExist(err) {
+			t.Errorf("stale unit %s was not removed (stat: %v)", name, err)
+		}
+	}
+
+	calls, _ := os.ReadFile(logFile)
+	if !strings.Contains(string(calls), "disable --now "+old+".timer\n") {
+		t.Errorf("stale timer was not disabled, systemctl calls:\n%s", calls)
+	}
+	if strings.Contains(string(calls), "disable --now "+keep) || strings.Contains(string(calls), "other") {
+		t.Errorf("current or foreign units were disabled, systemctl calls:\n%s", calls)
+	}
+	if !strings.Contains(string(calls), "daemon-reload\n") {
+		t.Errorf("systemd was not reloaded, systemctl calls:\n%s", calls)
+	}
+}
@@ -0,0 +1,307 @@
+// Package cronexpr parses standard 5-field cron expressions and computes their fire times.
+//
+// Supported syntax matches what Vixie cron and cronie accept in a crontab:
+// numbers, ranges (1-5), steps (*/15, 1-30/5, 10/5), lists (1,15,30),
+// month and weekday names (jan-dec, sun-sat) and the @yearly, @annually,
+// @monthly, @weekly, @daily, @midnight and @hourly macros. Expressions that
+// cron would silently ignore, such as six fields or @every, are rejected.
+// @reboot has no fire times, so Parse rejects it; IsReboot lets callers that
+// hand expressions to cron unchanged accept it.
+package cronexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bit n set if value n matches
+
+	// Cron matches a day if either day field matches when both are
+	// restricted, so whether each field was "*" is significant.
+	domAny, dowAny bool
+
+	fields [5]string // Normalized field text
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    []string // Names for min, min+1, ...
+}
+
+var fieldSpecs = [5]field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Reboot is the macro for jobs that run once when cron starts.
+const Reboot = "@reboot"
+
+// IsReboot reports whether expr is the @reboot macro.
+func IsReboot(expr string) bool {
+	return strings.EqualFold(strings.TrimSpace(expr), Reboot)
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@") {
+		expanded, ok := macros[strings.ToLower(expr)]
+		if IsReboot(expr) {
+			return nil, fmt.Errorf("%s has no schedule", Reboot)
+		}
+		if !ok {
+			return nil, fmt.Errorf("unsupported macro %q", expr)
+		}
+		expr = expanded
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(parts))
+	}
+
+	s := &Schedule{}
+	bits := [5]*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, part := range parts {
+		set, normalized, err := parseField(part, fieldSpecs[i])
+		if err != nil {
+			return nil, err
+		}
+		*bits[i] = set
+		s.fields[i] = normalized
+	}
+
+	// Sunday may be written as 0 or 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = strings.HasPrefix(parts[2], "*")
+	s.dowAny = strings.HasPrefix(parts[4], "*")
+	return s, nil
+}
+
+// String returns the normalized expression: macros expanded, names replaced
+// by numbers and whitespace collapsed.
+func (s *Schedule) String() string {
+	return strings.Join(s.fields[:], " ")
+}
+
+// Fields returns the normalized text of each of the five fields.
+func (s *Schedule) Fields() [5]string {
+	return s.fields
+}
+
+func parseField(text string, f field) (uint64, string, error) {
+	var set uint64
+	items := strings.Split(text, ",")
+	normalized := make([]string, len(items))
+
+	for i, item := range items {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		var lo, hi int
+		var norm string
+		switch {
+		case rangePart == "*":
+			lo, hi = f.min, f.max
+			norm = "*"
+		case strings.Contains(rangePart, "-"):
+			a, b, _ := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = parseValue(a, f); err != nil {
+				return 0, "", err
+			}
+			if hi, err = parseValue(b, f); err != nil {
+				return 0, "", err
+			}
+			if lo > hi {
+				return 0, "", fmt.Errorf("invalid %s range %q", f.name, rangePart)
+			}
+			norm = strconv.Itoa(lo) + "-" + strconv.Itoa(hi)
+		default:
+			v, err := parseValue(rangePart, f)
+			if err != nil {
+				return 0, "", err
+			}
+			lo, hi = v, v
+			if hasStep {
+				// "10/5" means "10-max/5".
+				hi = f.max
+			}
+			norm = strconv.Itoa(v)
+		}
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 || step > f.max {
+				return 0, "", fmt.Errorf("invalid %s step %q", f.name, stepPart)
+			}
+			norm += "/" + strconv.Itoa(step)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+		normalized[i] = norm
+	}
+	return set, strings.Join(normalized, ","), nil
+}
+
+func parseValue(text string, f field) (int, error) {
+	lower := strings.ToLower(text)
+	for i, name := range f.names {
+		if lower == name {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(text)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s value %q (must be %d-%d)", f.name, text, f.min, f.max)
+	}
+	return v, nil
+}
+
+var weekdayNames = [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// OnCalendar converts the schedule to systemd OnCalendar expressions
+// (systemd.time(7)). When both day fields are restricted, cron runs a job if
+// either matches, while systemd requires all components to match, so such
+// schedules yield two expressions whose union is the cron schedule.
+func (s *Schedule) OnCalendar() []string {
+	date := "*-" + calendarValues(s.month, 1, 12, 2) + "-"
+	clock := " " + calendarValues(s.hour, 0, 23, 2) + ":" + calendarValues(s.minute, 0, 59, 2) + ":00"
+	dom := calendarValues(s.dom, 1, 31, 2)
+	dow := calendarWeekdays(s.dow)
+
+	if s.domAny || s.dowAny {
+		// Both day fields must match, as in cron.
+		expr := date + dom + clock
+		if dow != "" {
+			expr = dow + " " + expr
+		}
+		return []string{expr}
+	}
+	return []string{
+		date + dom + clock,
+		dow + " " + date + "*" + clock,
+	}
+}
+
+// calendarValues formats a bit set as a systemd value list, using ranges for
+// consecutive values, or "*" if every value in [min, max] is set.
+func calendarValues(set uint64, min, max, width int) string {
+	var parts []string
+	all := true
+	for v := min; v <= max; {
+		if set&(1<<uint(v)) == 0 {
+			all = false
+			v++
+			continue
+		}
+		end := v
+		for end+1 <= max && set&(1<<uint(end+1)) != 0 {
+			end++
+		}
+		if end-v >= 2 {
+			parts = append(parts, pad(v, width)+".."+pad(end, width))
+		} else {
+			for i := v; i <= end; i++ {
+				parts = append(parts, pad(i, width))
+			}
+		}
+		v = end + 1
+	}
+	if all {
+		return "*"
+	}
+	return strings.Join(parts, ",")
+}
+
+// calendarWeekdays formats a weekday set, or "" if every day is set.
+func calendarWeekdays(set uint64) string {
+	if set&0x7f == 0x7f {
+		return ""
+	}
+	var parts []string
+	for d := 0; d < 7; d++ {
+		if set&(1<<uint(d)) != 0 {
+			parts = append(parts, weekdayNames[d])
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func pad(v, width int) string {
+	return fmt.Sprintf("%0*d", width, v)
+}
+
+// maxSearchYears bounds Next for schedules that can never fire, such as
+// "0 0 30 2 *" (February 30th).
+const maxSearchYears = 5
+
+// Next returns the first time strictly after t at which the schedule fires,
+// evaluated in t's location, or the zero Time if there is none. Wall clock
+// times that do not exist because of a DST transition are skipped.
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// A DST fall-back repeats the hour; step in absolute time.
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches applies cron's day rule: if either day field is "*", both must
+// match; otherwise a match of either is enough.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,157 @@
+package cronexpr
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse_Valid(t *testing.T) {
+	tests := map[string]string{
+		"*/5 * * * *":           "*/5 * * * *",
+		"0  2 * * *":            "0 2 * * *",
+		"@hourly":               "0 * * * *",
+		"@DAILY":                "0 0 * * *",
+		"@weekly":               "0 0 * * 0",
+		"0 9 * jan-mar mon-fri": "0 9 * 1-3 1-5",
+		"15,45 8-18/2 1 * SUN":  "15,45 8-18/2 1 * 0",
+		"10/20 * * * 7":         "10/20 * * * 7",
+		"0 0 31 12 *":           "0 0 31 12 *",
+	}
+	for expr, want := range tests {
+		s, err := Parse(expr)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", expr, err)
+			continue
+		}
+		if got := s.String(); got != want {
+			t.Errorf("Parse(%q).String() = %q, want %q", expr, got, want)
+		}
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"* * * *",
+		"0 * * * * *",
+		"@every 5m",
+		"@reboot",
+		"99 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"* * * foo *",
+	}
+	for _, expr := range invalid {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
+
+func TestParse_Sets(t *testing.T) {
+	s, err := Parse("10/20 * * * 7")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if want := uint64(1<<10 | 1<<30 | 1<<50); s.minute != want {
+		t.Errorf("minute set = %b, want %b", s.minute, want)
+	}
+	// Sunday written as 7 is the same day as 0.
+	if s.dow != 1 {
+		t.Errorf("dow set = %b, want 1", s.dow)
+	}
+	if !s.domAny || s.dowAny {
+		t.Errorf("domAny = %v, dowAny = %v", s.domAny, s.dowAny)
+	}
+}
+
+func TestOnCalendar(t *testing.T) {
+	tests := map[string][]string{
+		"*/15 * * * *":     {"*-*-* *:00,15,30,45:00"},
+		"0 2 * * *":        {"*-*-* 02:00:00"},
+		"30 9 * * mon-fri": {"Mon,Tue,Wed,Thu,Fri *-*-* 09:30:00"},
+		"0 0 1 */3 *":      {"*-01,04,07,10-01 00:00:00"},
+		"0 12 1,15 * 0":    {"*-*-01,15 12:00:00", "Sun *-*-* 12:00:00"},
+		"5 8-17 * * 7":     {"Sun *-*-* 08..17:05:00"},
+		"0 0 1-7 * */2":    {"Sun,Tue,Thu,Sat *-*-01..07 00:00:00"},
+	}
+	for expr, want := range tests {
+		s, err := Parse(expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", expr, err)
+		}
+		got := s.OnCalendar()
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("OnCalendar(%q) = %q, want %q", expr, got, want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 7, 30, 0, utc), time.Date(2024, 1, 1, 10, 15, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 15, 0, 0, utc), time.Date(2024, 1, 1, 10, 30, 0, 0, utc)},
+		{"0 2 * * *", time.Date(2024, 1, 31, 3, 0, 0, 0, utc), time.Date(2024, 2, 1, 2, 0, 0, 0, utc)},
+		{"0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, utc), time.Date(2028, 2, 29, 0, 0, 0, 0, utc)},
+		{"30 9 * * mon-fri", time.Date(2024, 6, 7, 10, 0, 0, 0, utc), time.Date(2024, 6, 10, 9, 30, 0, 0, utc)},
+		// Either day field may match when both are restricted (the 15th or a Monday).
+		{"0 12 15 * 1", time.Date(2024, 6, 11, 0, 0, 0, 0, utc), time.Date(2024, 6, 15, 12, 0, 0, 0, utc)},
+		{"0 12 15 * 1", time.Date(2024, 6, 8, 0, 0, 0, 0, utc), time.Date(2024, 6, 10, 12, 0, 0, 0, utc)},
+		{"0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("Next(%q, %v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestNext_Location(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	s, _ := Parse("30 2 * * *")
+
+	// 02:30 does not exist on the spring-forward day; the run moves to the next day.
+	got := s.Next(time.Date(2024, 3, 10, 0, 0, 0, 0, loc))
+	if want := time.Date(2024, 3, 11, 2, 30, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("Next across DST gap = %v, want %v", got, want)
+	}
+
+	s, _ = Parse("0 9 * * *")
+	got = s.Next(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC).In(loc))
+	if want := time.Date(2024, 7, 1, 13, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next in New York = %v, want %v", got, want)
+	}
+}
+
+func TestIsReboot(t *testing.T) {
+	for _, expr := range []string{"@reboot", " @REBOOT "} {
+		if !IsReboot(expr) {
+			t.Errorf("IsReboot(%q) = false", expr)
+		}
+	}
+	for _, expr := range []string{"@daily", "@reboot 5", "0 * * * *"} {
+		if IsReboot(expr) {
+			t.Errorf("IsReboot(%q) = true", expr)
+		}
+	}
+}
@@ -34,8 +34,10 @@ type HeartbeatAckMessage struct {
 }
 
 // ExecutionReportPayload contains the execution details
-// SECURITY: All fields are logged verbatim for auditability - commands are NOT redacted.
+// SECURITY: Commands are logged verbatim for auditability and are NOT redacted.
+// Stdout/Stderr are redacted for secrets and credential patterns before sending.
 type ExecutionReportPayload struct {
+	ExecutionID   string `json:"executionId,omitempty"` // Agent-generated, unique per execution; echoed in report_ack and used by the server to dedupe
 	JobID         string `json:"jobId"`
 	Command       string `json:"command"`
 	ExitCode      int    `json:"exitCode"`
@@ -46,6 +48,32 @@ type ExecutionReportPayload struct {
 	Stderr        string `json:"stderr"`
 	StartTime     string `json:"startTime"`
 	DurationMs    int    `json:"durationMs"`
+	TimedOut      bool   `json:"timedOut,omitempty"`     // Killed after exceeding the job's timeout
+	Status        string `json:"status,omitempty"`       // Set when the run did not execute normally, e.g. "skipped"
+	Trigger       string `json:"trigger,omitempty"`      // "scheduled" or "manual"
+	CancelledBy   string `json:"cancelledBy,omitempty"`  // Who cancelled the run, when Status is "cancelled"
+	StartDelayMs  int    `json:"startDelayMs,omitempty"` // Jitter delay slept before the command started
+	RunID         string `json:"runId,omitempty"`        // Shared by all attempts of a run; the first attempt's execution ID
+	Attempt       int    `json:"attempt,omitempty"`      // 1 for the first attempt, incremented per retry
+
+	// Termination details and resource usage of the child process
+	Signal        string         `json:"signal,omitempty"` // Terminating signal name, e.g. "SIGKILL"; ExitCode is then -1
+	CoreDumped    bool           `json:"coreDumped,omitempty"`
+	LimitExceeded string         `json:"limitExceeded,omitempty"` // Resource limit that terminated the job, e.g. "cpuSeconds"
+	Rusage        *ResourceUsage `json:"rusage,omitempty"`
+
+	Redactions int `json:"redactions,omitempty"` // Number of sensitive values replaced in stdout/stderr
+}
+
+// ResourceUsage is the child's resource usage as reported by getrusage(2)
+type ResourceUsage struct {
+	UserCPUMs              int64 `json:"userCpuMs"`
+	SystemCPUMs            int64 `json:"systemCpuMs"`
+	MaxRSSKB               int64 `json:"maxRssKb"`
+	InBlock                int64 `json:"inBlock"`  // Block input operations
+	OutBlock               int64 `json:"outBlock"` // Block output operations
+	VoluntaryCtxSwitches   int64 `json:"voluntaryCtxSwitches"`
+	InvoluntaryCtxSwitches int64 `json:"involuntaryCtxSwitches"`
 }
 
 // ExecutionReportMessage wraps an execution report
@@ -54,23 +82,207 @@ type ExecutionReportMessage struct {
 	Payload ExecutionReportPayload `json:"payload"`
 }
 
+// ExecutionStartedPayload announces an execution that has just started
+type ExecutionStartedPayload struct {
+	ExecutionID string `json:"executionId"`
+	JobID       string `json:"jobId"`
+	Command     string `json:"command"`
+	StartTime   string `json:"startTime"`
+	Trigger     string `json:"trigger,omitempty"`
+}
+
+// ExecutionStartedMessage wraps an execution start announcement
+type ExecutionStartedMessage struct {
+	Type    string                  `json:"type"`
+	Payload ExecutionStartedPayload `json:"payload"`
+}
+
+// ExecutionOutputPayload is an incremental chunk of a running execution's output.
+// Seq increases by one per chunk across both streams; gaps mean chunks were lost.
+type ExecutionOutputPayload struct {
+	ExecutionID  string `json:"executionId"`
+	Seq          int64  `json:"seq"`
+	Stream       string `json:"stream"` // "stdout" or "stderr"
+	Data         string `json:"data"`
+	DroppedBytes int64  `json:"droppedBytes,omitempty"` // Output dropped before this chunk due to backpressure
+}
+
+// ExecutionOutputMessage wraps an output chunk
+type ExecutionOutputMessage struct {
+	Type    string                 `json:"type"`
+	Payload ExecutionOutputPayload `json:"payload"`
+}
+
 // ReportAckMessage acknowledges receipt of an execution report
 type ReportAckMessage struct {
 	Type        string `json:"type"`
 	ExecutionID string `json:"executionId"`
 }
 
+// RunJobMessage asks the agent to run a synced job immediately, outside its schedule
+type RunJobMessage struct {
+	Type        string `json:"type"`
+	JobID       string `json:"jobId"`
+	ExecutionID string `json:"executionId,omitempty"` // Optional; generated by the agent if empty
+}
+
+// RunJobAckMessage reports whether a run_job request was started
+type RunJobAckMessage struct {
+	Type        string `json:"type"`
+	JobID       string `json:"jobId"`
+	ExecutionID string `json:"executionId,omitempty"`
+	Status      string `json:"status"` // "started" or "rejected"
+	Reason      string `json:"reason,omitempty"`
+}
+
+// ExecAdhocMessage asks the agent to run a one-off command, subject to local policy.
+// The command is executed directly from Argv, never through a shell.
+type ExecAdhocMessage struct {
+	Type           string   `json:"type"`
+	ExecutionID    string   `json:"executionId,omitempty"` // Optional; generated by the agent if empty
+	Argv           []string `json:"argv"`
+	TimeoutSeconds int      `json:"timeoutSeconds,omitempty"` // Capped by the agent
+}
+
+// ExecAdhocAckMessage reports whether an exec_adhoc request was started
+type ExecAdhocAckMessage struct {
+	Type        string `json:"type"`
+	ExecutionID string `json:"executionId,omitempty"`
+	Status      string `json:"status"` // "started" or "rejected"
+	Reason      string `json:"reason,omitempty"`
+}
+
+// CancelExecutionMessage asks the agent to stop a running execution
+type CancelExecutionMessage struct {
+	Type        string `json:"type"`
+	ExecutionID string `json:"executionId"`
+	CancelledBy string `json:"cancelledBy,omitempty"` // Recorded in the final report
+}
+
+// CancelExecutionAckMessage reports the outcome of a cancel_execution request
+type CancelExecutionAckMessage struct {
+	Type        string `json:"type"`
+	ExecutionID string `json:"executionId"`
+	Status      string `json:"status"` // "cancelling", "not_found" or "failed"
+	Reason      string `json:"reason,omitempty"`
+}
+
 // SyncJobsMessage contains job definitions to sync
 type SyncJobsMessage struct {
 	Type string          `json:"type"`
 	Jobs []JobDefinition `json:"jobs"`
+	// RedactPatterns are regular expressions applied to job output, in addition
+	// to the agent's built-in and locally configured patterns
+	RedactPatterns []string `json:"redactPatterns,omitempty"`
+}
+
+// SyncResultMessage reports which jobs from a sync_jobs passed validation and
+// which were rejected by the agent. It is sent as soon as the jobs are
+// validated, before they are installed; SyncAckMessage follows with the
+// outcome of the installation
+type SyncResultMessage struct {
+	Type     string         `json:"type"`
+	Accepted []string       `json:"accepted"` // Job IDs
+	Rejected []JobRejection `json:"rejected"`
+}
+
+// SyncAckMessage reports the outcome of installing jobs from a sync_jobs.
+// Skipped repeats the rejections from the preceding SyncResultMessage so the
+// ack alone describes the host's state
+type SyncAckMessage struct {
+	Type          string         `json:"type"`
+	Outcome       string         `json:"outcome"`   // "applied" or "failed"
+	Installed     int            `json:"installed"` // Number of jobs in the installed cron table
+	Skipped       []JobRejection `json:"skipped"`
+	ContentHash   string         `json:"contentHash,omitempty"`   // SHA-256 of the installed cron content
+	Error         string         `json:"error,omitempty"`         // Why installation failed
+	CrontabOutput string         `json:"crontabOutput,omitempty"` // Output of "crontab -" in user mode
+}
+
+// DriftDetectedMessage reports that the installed cron table was changed
+// outside the agent
+type DriftDetectedMessage struct {
+	Type         string `json:"type"`
+	Target       string `json:"target"`       // Cron file path or "user crontab"
+	ExpectedHash string `json:"expectedHash"` // SHA-256 of the content the agent installed
+	ActualHash   string `json:"actualHash"`   // SHA-256 of the content found
+	Action       string `json:"action"`       // Configured drift action: "report" or "repair"
+	Repaired     bool   `json:"repaired,omitempty"`
+	Error        string `json:"error,omitempty"` // Why repair failed
+}
+
+// DiscoveredJobsMessage lists existing cron entries not managed by the agent,
+// so they can be adopted as managed jobs
+type DiscoveredJobsMessage struct {
+	Type string          `json:"type"`
+	Jobs []DiscoveredJob `json:"jobs"`
+}
+
+// DiscoveredJob is an unmanaged cron entry found on the host
+type DiscoveredJob struct {
+	Source         string `json:"source"` // File path, or "crontab:<user>" for a user crontab
+	Line           int    `json:"line"`
+	User           string `json:"user"`
+	CronExpression string `json:"cronExpression"` // Normalized if valid
+	Command        string `json:"command"`
+	Fingerprint    string `json:"fingerprint"`     // Stable ID for the entry
+	Error          string `json:"error,omitempty"` // Why the schedule is not valid
+}
+
+// JobRejection explains why a job was not installed
+type JobRejection struct {
+	JobID  string `json:"jobId"`
+	Reason string `json:"reason"`
 }
 
 // JobDefinition represents a cron job to be synced
 type JobDefinition struct {
-	JobID          string `json:"jobId"`
-	CronExpression string `json:"cronExpression"`
-	Command        string `json:"command"`
+	JobID             string `json:"jobId"`
+	CronExpression    string `json:"cronExpression"`
+	Command           string `json:"command"`
+	TimeoutSeconds    int    `json:"timeoutSeconds,omitempty"`    // 0 = no timeout
+	ConcurrencyPolicy string `json:"concurrencyPolicy,omitempty"` // "allow" (default), "forbid" or "replace"
+	RunAs             string `json:"runAs,omitempty"`             // Local user to run as (system mode only)
+	RunAsGroup        string `json:"runAsGroup,omitempty"`        // Overrides the user's primary group
+	Timezone          string `json:"timezone,omitempty"`          // IANA zone for the schedule, e.g. "Europe/Berlin"; default host zone
+	JitterSeconds     int    `json:"jitterSeconds,omitempty"`     // Upper bound of a per-host start delay that spreads runs across a fleet
+	Retries           int    `json:"retries,omitempty"`           // Re-runs after a failed attempt, 0 = none
+	RetryDelaySeconds int    `json:"retryDelaySeconds,omitempty"` // Delay before the first retry, doubled for each further one
+	RetryOnExitCodes  []int  `json:"retryOnExitCodes,omitempty"`  // Exit codes that are retried; empty = any non-zero
+
+	// Limits are applied with setrlimit to the job's process
+	Limits *ResourceLimits `json:"limits,omitempty"`
+
+	// Env and WorkingDir are delivered to exec mode via a private spec file, never the cron file
+	Env        map[string]string `json:"env,omitempty"`
+	WorkingDir string            `json:"workingDir,omitempty"`
+
+	// Secrets reference values held in the agent's local secret store
+	Secrets []SecretRef `json:"secrets,omitempty"`
+}
+
+// ResourceLimits caps a job's resources. Unset fields leave the limit inherited
+// from the agent unchanged.
+type ResourceLimits struct {
+	AddressSpaceMB *int64 `json:"addressSpaceMb,omitempty"` // Virtual memory (RLIMIT_AS)
+	OpenFiles      *int64 `json:"openFiles,omitempty"`      // RLIMIT_NOFILE
+	CPUSeconds     *int64 `json:"cpuSeconds,omitempty"`     // RLIMIT_CPU; the job receives SIGXCPU when exceeded
+	Processes      *int64 `json:"processes,omitempty"`      // RLIMIT_NPROC; counts all processes of the job's user
+	CoreSizeMB     *int64 `json:"coreSizeMb,omitempty"`     // RLIMIT_CORE; 0 disables core dumps
+	FileSizeMB     *int64 `json:"fileSizeMb,omitempty"`     // RLIMIT_FSIZE; the job receives SIGXFSZ when exceeded
+}
+
+// SecretRef injects a secret from the local store into a job's environment
+type SecretRef struct {
+	Name string `json:"name"`           // Secret name in the local store
+	Env  string `json:"env"`            // Environment variable to set
+	File bool   `json:"file,omitempty"` // If true, Env receives the path of a temp file holding the value
+}
+
+// SyncSecretsMessage replaces the agent's local secret store
+type SyncSecretsMessage struct {
+	Type    string            `json:"type"`
+	Secrets map[string]string `json:"secrets"`
 }
 
 // ErrorMessage indicates a protocol error